@@ -0,0 +1,68 @@
+// Command admin lists or purges the keys of the namespace set by
+// NAMESPACE_ENV and NAMESPACE_APP, reading the same config.env as the server.
+//
+//	admin list
+//	admin purge -yes
+//
+// With DATASTORE=bolt the file is locked by the process that opened it, so
+// stop the server before running the command.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/jpodlasnisky/ratelimiter/config"
+	"github.com/jpodlasnisky/ratelimiter/infra/web/server"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		log.Fatal("Error loading config:", err)
+	}
+
+	rateLimiter := server.NewRateLimiter(cfg)
+	ctx := context.Background()
+
+	switch os.Args[1] {
+	case "list":
+		keys, err := rateLimiter.NamespaceKeys(ctx)
+		if err != nil {
+			log.Fatal("Erro ao listar o namespace:", err)
+		}
+		for _, key := range keys {
+			fmt.Println(key)
+		}
+
+	case "purge":
+		flags := flag.NewFlagSet("purge", flag.ExitOnError)
+		confirmed := flags.Bool("yes", false, "confirma a remoção das janelas e bloqueios do namespace")
+		_ = flags.Parse(os.Args[2:])
+
+		if !*confirmed {
+			log.Fatal("Use -yes para confirmar a remoção das janelas e bloqueios do namespace")
+		}
+
+		purged, err := rateLimiter.PurgeNamespace(ctx)
+		if err != nil {
+			log.Fatalf("Erro ao limpar o namespace depois de %d chaves: %v", purged, err)
+		}
+		fmt.Printf("%d chaves removidas\n", purged)
+
+	default:
+		usage()
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "uso: admin list | admin purge -yes")
+	os.Exit(2)
+}
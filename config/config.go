@@ -4,6 +4,7 @@ import (
 	"log"
 	"os"
 	"strconv"
+	"strings"
 
 	"github.com/joho/godotenv"
 )
@@ -14,7 +15,61 @@ type Config struct {
 	LockDurationSeconds       int
 	BlockDurationSeconds      int
 	WebPort                   string
+	ServerMode                string
+	GRPCPort                  string
+	ProxyRoutes               []string
+	TokenExtractors           []string
+	InvalidTokenMode          string
+	InvalidTokenPenalty       int
+	InvalidTokenMaxAttempts   int
+	InvalidTokenWindowSeconds int
+	ProxyHealthPath           string
+	ProxyHealthCheckSeconds   int
+	Datastore                 string
+	BoltPath                  string
+	BoltCompactionSeconds     int
 	RedisURL                  string
+	RedisMode                 string
+	RedisAddrs                []string
+	RedisMasterName           string
+	RedisSentinelPassword     string
+	RedisUsername             string
+	RedisPassword             string
+	RedisDB                   int
+	RedisTLSEnabled           bool
+	RedisTLSCAFile            string
+	RedisTLSCertFile          string
+	RedisTLSKeyFile           string
+	RedisTLSServerName        string
+	RedisPoolSize             int
+	RedisMinIdleConns         int
+	RedisMaxRetries           int
+	RedisDialTimeoutMs        int
+	RedisReadTimeoutMs        int
+	RedisWriteTimeoutMs       int
+	RedisPoolTimeoutMs        int
+	BlockCacheSize            int
+	TokenHashSecret           string
+	JWTHMACSecret             string
+	JWTJWKSFile               string
+	JWTKeyClaim               string
+	JWTPlanClaim              string
+	JWTPlans                  map[string]int64
+	JWTDefaultLimit           int
+	TLSCertFile               string
+	TLSKeyFile                string
+	TLSClientCAFile           string
+	MTLSIdentity              string
+	MTLSPolicies              map[string]int64
+	MTLSDefaultLimit          int
+	TokenTenants              map[string]string
+	OrganizationLimits        map[string]int64
+	ProjectLimits             map[string]int64
+	KeyRules                  []string
+	NamespaceEnv              string
+	NamespaceApp              string
+	TokenSchedules            map[string]string
+	TokenRotations            map[string]string
 }
 
 func LoadConfig() (*Config, error) {
@@ -32,10 +87,64 @@ func LoadConfig() (*Config, error) {
 			"TOKEN_4": int64(getEnvAsInt("TOKEN_4_MAX_REQUESTS_PER_SECOND")),
 			"TOKEN_5": int64(getEnvAsInt("TOKEN_5_MAX_REQUESTS_PER_SECOND")),
 		},
-		LockDurationSeconds:  getEnvAsInt("LOCK_DURATION_SECONDS"),
-		BlockDurationSeconds: getEnvAsInt("BLOCK_DURATION_SECONDS"),
-		WebPort:              os.Getenv("APP_WEB_PORT"),
-		RedisURL:             os.Getenv("REDIS_URL"),
+		LockDurationSeconds:       getEnvAsInt("LOCK_DURATION_SECONDS"),
+		BlockDurationSeconds:      getEnvAsInt("BLOCK_DURATION_SECONDS"),
+		WebPort:                   os.Getenv("APP_WEB_PORT"),
+		ServerMode:                getEnvOrDefault("SERVER_MODE", "middleware"),
+		GRPCPort:                  getEnvOrDefault("GRPC_PORT", "8081"),
+		ProxyRoutes:               getEnvAsList("PROXY_ROUTES"),
+		TokenExtractors:           getEnvAsList("TOKEN_EXTRACTORS"),
+		InvalidTokenMode:          getEnvOrDefault("INVALID_TOKEN_MODE", "ip"),
+		InvalidTokenPenalty:       getEnvAsIntOrDefault("INVALID_TOKEN_PENALTY", 2),
+		InvalidTokenMaxAttempts:   getEnvAsIntOrDefault("INVALID_TOKEN_MAX_ATTEMPTS", 0),
+		InvalidTokenWindowSeconds: getEnvAsIntOrDefault("INVALID_TOKEN_WINDOW_SECONDS", 60),
+		ProxyHealthPath:           getEnvOrDefault("PROXY_HEALTH_PATH", "/healthz"),
+		ProxyHealthCheckSeconds:   getEnvAsIntOrDefault("PROXY_HEALTH_CHECK_SECONDS", 10),
+		Datastore:                 getEnvOrDefault("DATASTORE", "redis"),
+		BoltPath:                  getEnvOrDefault("BOLT_PATH", "ratelimiter.db"),
+		BoltCompactionSeconds:     getEnvAsIntOrDefault("BOLT_COMPACTION_SECONDS", 60),
+		RedisURL:                  os.Getenv("REDIS_URL"),
+		RedisMode:                 getEnvOrDefault("REDIS_MODE", "single"),
+		RedisAddrs:                getEnvAsList("REDIS_ADDRS"),
+		RedisMasterName:           os.Getenv("REDIS_MASTER_NAME"),
+		RedisSentinelPassword:     os.Getenv("REDIS_SENTINEL_PASSWORD"),
+		RedisUsername:             os.Getenv("REDIS_USERNAME"),
+		RedisPassword:             os.Getenv("REDIS_PASSWORD"),
+		RedisDB:                   getEnvAsIntOrDefault("REDIS_DB", 0),
+		RedisTLSEnabled:           getEnvAsBoolOrDefault("REDIS_TLS_ENABLED", false),
+		RedisTLSCAFile:            os.Getenv("REDIS_TLS_CA_FILE"),
+		RedisTLSCertFile:          os.Getenv("REDIS_TLS_CERT_FILE"),
+		RedisTLSKeyFile:           os.Getenv("REDIS_TLS_KEY_FILE"),
+		RedisTLSServerName:        os.Getenv("REDIS_TLS_SERVER_NAME"),
+		RedisPoolSize:             getEnvAsIntOrDefault("REDIS_POOL_SIZE", 0),
+		RedisMinIdleConns:         getEnvAsIntOrDefault("REDIS_MIN_IDLE_CONNS", 0),
+		RedisMaxRetries:           getEnvAsIntOrDefault("REDIS_MAX_RETRIES", 0),
+		RedisDialTimeoutMs:        getEnvAsIntOrDefault("REDIS_DIAL_TIMEOUT_MS", 0),
+		RedisReadTimeoutMs:        getEnvAsIntOrDefault("REDIS_READ_TIMEOUT_MS", 0),
+		RedisWriteTimeoutMs:       getEnvAsIntOrDefault("REDIS_WRITE_TIMEOUT_MS", 0),
+		RedisPoolTimeoutMs:        getEnvAsIntOrDefault("REDIS_POOL_TIMEOUT_MS", 0),
+		BlockCacheSize:            getEnvAsIntOrDefault("BLOCK_CACHE_SIZE", 10000),
+		TokenHashSecret:           os.Getenv("TOKEN_HASH_SECRET"),
+		JWTHMACSecret:             os.Getenv("JWT_HMAC_SECRET"),
+		JWTJWKSFile:               os.Getenv("JWT_JWKS_FILE"),
+		JWTKeyClaim:               getEnvOrDefault("JWT_KEY_CLAIM", "sub"),
+		JWTPlanClaim:              getEnvOrDefault("JWT_PLAN_CLAIM", "plan"),
+		JWTPlans:                  getEnvAsLimitMap("JWT_PLANS"),
+		JWTDefaultLimit:           getEnvAsIntOrDefault("JWT_DEFAULT_LIMIT", 0),
+		TLSCertFile:               os.Getenv("TLS_CERT_FILE"),
+		TLSKeyFile:                os.Getenv("TLS_KEY_FILE"),
+		TLSClientCAFile:           os.Getenv("TLS_CLIENT_CA_FILE"),
+		MTLSIdentity:              getEnvOrDefault("MTLS_IDENTITY", "cn"),
+		MTLSPolicies:              getEnvAsLimitMap("MTLS_POLICIES"),
+		MTLSDefaultLimit:          getEnvAsIntOrDefault("MTLS_DEFAULT_LIMIT", 0),
+		TokenTenants:              getEnvAsStringMap("TOKEN_TENANTS"),
+		OrganizationLimits:        getEnvAsLimitMap("ORGANIZATION_LIMITS"),
+		ProjectLimits:             getEnvAsLimitMap("PROJECT_LIMITS"),
+		KeyRules:                  getEnvAsList("KEY_RULES"),
+		NamespaceEnv:              os.Getenv("NAMESPACE_ENV"),
+		NamespaceApp:              os.Getenv("NAMESPACE_APP"),
+		TokenSchedules:            getEnvAsStringMap("TOKEN_SCHEDULES"),
+		TokenRotations:            getEnvAsStringMap("TOKEN_ROTATIONS"),
 	}
 
 	return config, nil
@@ -49,3 +158,71 @@ func getEnvAsInt(name string) int {
 	}
 	return value
 }
+
+func getEnvAsIntOrDefault(name string, defaultValue int) int {
+	if os.Getenv(name) == "" {
+		return defaultValue
+	}
+	return getEnvAsInt(name)
+}
+
+func getEnvAsBoolOrDefault(name string, defaultValue bool) bool {
+	valueStr := os.Getenv(name)
+	if valueStr == "" {
+		return defaultValue
+	}
+	value, err := strconv.ParseBool(valueStr)
+	if err != nil {
+		log.Fatal("Error converting "+name+" to bool:", err)
+	}
+	return value
+}
+
+func getEnvOrDefault(name, defaultValue string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return defaultValue
+}
+
+func getEnvAsList(name string) []string {
+	var values []string
+	for _, value := range strings.Split(os.Getenv(name), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// getEnvAsStringMap reads a list like "TOKEN_1:acme/web,TOKEN_2:acme".
+func getEnvAsStringMap(name string) map[string]string {
+	values := make(map[string]string)
+	for _, entry := range getEnvAsList(name) {
+		key, value, found := strings.Cut(entry, ":")
+		if !found {
+			log.Fatal("Error reading " + name + " entry " + entry + ": missing value")
+		}
+		values[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+	return values
+}
+
+// getEnvAsLimitMap reads a list like "gold:100,silver:20". The limit follows
+// the last colon, so keys like SPIFFE IDs may contain colons.
+func getEnvAsLimitMap(name string) map[string]int64 {
+	limits := make(map[string]int64)
+	for _, entry := range getEnvAsList(name) {
+		sep := strings.LastIndex(entry, ":")
+		if sep < 0 {
+			log.Fatal("Error converting " + name + " entry " + entry + " to a limit: missing limit")
+		}
+		key, valueStr := entry[:sep], entry[sep+1:]
+		value, err := strconv.ParseInt(strings.TrimSpace(valueStr), 10, 64)
+		if err != nil {
+			log.Fatal("Error converting "+name+" entry "+entry+" to a limit:", err)
+		}
+		limits[strings.TrimSpace(key)] = value
+	}
+	return limits
+}
@@ -2,28 +2,59 @@ package config
 
 import (
 	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/jpodlasnisky/ratelimiter/config"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
+// chdirTemp runs the test from an empty temporary directory, as LoadConfig
+// reads config.env from the working directory.
+func chdirTemp(t *testing.T) string {
+	dir := t.TempDir()
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	return dir
+}
+
+func writeConfigEnv(t *testing.T, dir, contents string) {
+	if err := os.WriteFile(filepath.Join(dir, "config.env"), []byte(contents), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
 func TestLoadConfig(t *testing.T) {
+	writeConfigEnv(t, chdirTemp(t), "APP_WEB_PORT=8080\nREDIS_POOL_SIZE=20\n")
+
 	// Define environment variables for testing
-	os.Setenv("IP_MAX_REQUESTS_PER_SECOND", "100")
-	os.Setenv("TOKEN_1_MAX_REQUESTS_PER_SECOND", "200")
-	os.Setenv("TOKEN_2_MAX_REQUESTS_PER_SECOND", "300")
-	os.Setenv("TOKEN_3_MAX_REQUESTS_PER_SECOND", "400")
-	os.Setenv("TOKEN_4_MAX_REQUESTS_PER_SECOND", "500")
-	os.Setenv("TOKEN_5_MAX_REQUESTS_PER_SECOND", "600")
-	os.Setenv("LOCK_DURATION_SECONDS", "10")
-	os.Setenv("BLOCK_DURATION_SECONDS", "20")
-	os.Setenv("APP_WEB_PORT", "8080")
-	os.Setenv("REDIS_URL", "redis://localhost:6379")
+	t.Setenv("IP_MAX_REQUESTS_PER_SECOND", "100")
+	t.Setenv("TOKEN_1_MAX_REQUESTS_PER_SECOND", "200")
+	t.Setenv("TOKEN_2_MAX_REQUESTS_PER_SECOND", "300")
+	t.Setenv("TOKEN_3_MAX_REQUESTS_PER_SECOND", "400")
+	t.Setenv("TOKEN_4_MAX_REQUESTS_PER_SECOND", "500")
+	t.Setenv("TOKEN_5_MAX_REQUESTS_PER_SECOND", "600")
+	t.Setenv("LOCK_DURATION_SECONDS", "10")
+	t.Setenv("BLOCK_DURATION_SECONDS", "20")
+	t.Setenv("REDIS_URL", "redis://localhost:6379")
+	t.Setenv("REDIS_PASSWORD", "secret")
+	t.Setenv("REDIS_TLS_ENABLED", "true")
+
+	// Variáveis já definidas no ambiente têm prioridade sobre o config.env
+	t.Setenv("REDIS_POOL_SIZE", "30")
 
 	config, err := config.LoadConfig()
+	require.NoError(t, err)
 
-	assert.NoError(t, err)
 	assert.Equal(t, 100, config.IPMaxRequestsPerSecond)
 	assert.Equal(t, int64(200), config.TokenMaxRequestsPerSecond["TOKEN_1"])
 	assert.Equal(t, int64(300), config.TokenMaxRequestsPerSecond["TOKEN_2"])
@@ -34,4 +65,15 @@ func TestLoadConfig(t *testing.T) {
 	assert.Equal(t, 20, config.BlockDurationSeconds)
 	assert.Equal(t, "8080", config.WebPort)
 	assert.Equal(t, "redis://localhost:6379", config.RedisURL)
+	assert.Equal(t, "secret", config.RedisPassword)
+	assert.True(t, config.RedisTLSEnabled)
+	assert.Equal(t, 30, config.RedisPoolSize)
+	assert.Equal(t, 0, config.RedisDB)
+}
+
+func TestLoadConfig_MissingFile(t *testing.T) {
+	chdirTemp(t)
+
+	_, err := config.LoadConfig()
+	assert.Error(t, err)
 }
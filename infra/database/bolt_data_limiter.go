@@ -0,0 +1,565 @@
+package database
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/jpodlasnisky/ratelimiter/infra/database/contract_db"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	boltValuesBucket     = []byte("values")
+	boltSortedSetsBucket = []byte("sorted_sets")
+)
+
+// BoltDataLimiter is a file-backed Datastore for single-node deployments
+// without Redis. Plain keys are kept with their expiry and each sorted set is
+// a nested bucket of member -> score, so limits and blocks survive a restart.
+type BoltDataLimiter struct {
+	db *bolt.DB
+
+	mu          sync.Mutex
+	subscribers map[string][]chan string
+}
+
+func NewBoltDataLimiter(path string) (*BoltDataLimiter, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("opening %s: %w", path, err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(boltValuesBucket); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucketIfNotExists(boltSortedSetsBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &BoltDataLimiter{db: db, subscribers: make(map[string][]chan string)}, nil
+}
+
+func (b *BoltDataLimiter) Close() error {
+	return b.db.Close()
+}
+
+func (b *BoltDataLimiter) ZRemRangeByScore(ctx context.Context, key, min, max string) (int64, error) {
+	minScore, minExclusive, err := parseScoreBound(min)
+	if err != nil {
+		return 0, err
+	}
+	maxScore, maxExclusive, err := parseScoreBound(max)
+	if err != nil {
+		return 0, err
+	}
+
+	var removed int64
+	err = b.db.Update(func(tx *bolt.Tx) error {
+		set := tx.Bucket(boltSortedSetsBucket).Bucket([]byte(key))
+		if set == nil {
+			return nil
+		}
+
+		var members [][]byte
+		err := set.ForEach(func(member, score []byte) error {
+			if scoreInRange(decodeScore(score), minScore, minExclusive, maxScore, maxExclusive) {
+				members = append(members, append([]byte(nil), member...))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, member := range members {
+			if err := set.Delete(member); err != nil {
+				return err
+			}
+		}
+		removed = int64(len(members))
+		return nil
+	})
+	return removed, err
+}
+
+func (b *BoltDataLimiter) ZCard(ctx context.Context, key string) (int64, error) {
+	var count int64
+	err := b.db.View(func(tx *bolt.Tx) error {
+		if set := tx.Bucket(boltSortedSetsBucket).Bucket([]byte(key)); set != nil {
+			count = int64(bucketLen(set))
+		}
+		return nil
+	})
+	return count, err
+}
+
+func (b *BoltDataLimiter) ZAdd(ctx context.Context, key string, members ...*redis.Z) (int64, error) {
+	var added int64
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		set, err := tx.Bucket(boltSortedSetsBucket).CreateBucketIfNotExists([]byte(key))
+		if err != nil {
+			return err
+		}
+
+		for _, z := range members {
+			member := toBytes(z.Member)
+			if set.Get(member) == nil {
+				added++
+			}
+			if err := set.Put(member, encodeScore(z.Score)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return added, err
+}
+
+func (b *BoltDataLimiter) ZRem(ctx context.Context, key string, members ...interface{}) (int64, error) {
+	var removed int64
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		set := tx.Bucket(boltSortedSetsBucket).Bucket([]byte(key))
+		if set == nil {
+			return nil
+		}
+
+		for _, m := range members {
+			member := toBytes(m)
+			if set.Get(member) == nil {
+				continue
+			}
+			if err := set.Delete(member); err != nil {
+				return err
+			}
+			removed++
+		}
+		return nil
+	})
+	return removed, err
+}
+
+// ZRangeWithScores accepts negative positions counted from the end, as Redis does.
+func (b *BoltDataLimiter) ZRangeWithScores(ctx context.Context, key string, start, stop int64) ([]redis.Z, error) {
+	var members []redis.Z
+	err := b.db.View(func(tx *bolt.Tx) error {
+		set := tx.Bucket(boltSortedSetsBucket).Bucket([]byte(key))
+		if set == nil {
+			return nil
+		}
+		return set.ForEach(func(member, score []byte) error {
+			members = append(members, redis.Z{Score: decodeScore(score), Member: string(member)})
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(members, func(i, j int) bool {
+		if members[i].Score == members[j].Score {
+			return members[i].Member.(string) < members[j].Member.(string)
+		}
+		return members[i].Score < members[j].Score
+	})
+
+	size := int64(len(members))
+	if start < 0 {
+		start += size
+	}
+	if stop < 0 {
+		stop += size
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= size {
+		stop = size - 1
+	}
+	if start > stop {
+		return []redis.Z{}, nil
+	}
+	return members[start : stop+1], nil
+}
+
+func (b *BoltDataLimiter) SetEX(ctx context.Context, key string, value interface{}, expiration time.Duration) error {
+	return b.Set(ctx, key, value, expiration)
+}
+
+func (b *BoltDataLimiter) Exists(ctx context.Context, keys ...string) (int64, error) {
+	var count int64
+	now := time.Now()
+	err := b.db.View(func(tx *bolt.Tx) error {
+		for _, key := range keys {
+			if _, ok := getValue(tx, key, now); ok {
+				count++
+			} else if set := tx.Bucket(boltSortedSetsBucket).Bucket([]byte(key)); set != nil && bucketLen(set) > 0 {
+				count++
+			}
+		}
+		return nil
+	})
+	return count, err
+}
+
+func (b *BoltDataLimiter) Get(ctx context.Context, key string) (string, error) {
+	var value []byte
+	var found bool
+	err := b.db.View(func(tx *bolt.Tx) error {
+		var entry boltEntry
+		entry, found = getValue(tx, key, time.Now())
+		value = entry.value
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	if !found {
+		return "", contract_db.ErrNotFound
+	}
+	return string(value), nil
+}
+
+func (b *BoltDataLimiter) Set(ctx context.Context, key string, value interface{}, expiration time.Duration) error {
+	entry := boltEntry{value: toBytes(value)}
+	if expiration > 0 {
+		entry.expiresAt = time.Now().Add(expiration).UnixNano()
+	}
+
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltValuesBucket).Put([]byte(key), entry.encode())
+	})
+}
+
+func (b *BoltDataLimiter) Del(ctx context.Context, keys ...string) (int64, error) {
+	var removed int64
+	now := time.Now()
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		values := tx.Bucket(boltValuesBucket)
+		sets := tx.Bucket(boltSortedSetsBucket)
+
+		for _, key := range keys {
+			if _, ok := getValue(tx, key, now); ok {
+				removed++
+			} else if set := sets.Bucket([]byte(key)); set != nil && bucketLen(set) > 0 {
+				removed++
+			}
+
+			if err := values.Delete([]byte(key)); err != nil {
+				return err
+			}
+			if sets.Bucket([]byte(key)) != nil {
+				if err := sets.DeleteBucket([]byte(key)); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	return removed, err
+}
+
+// ExtendTTL pushes the expiry of a plain value forward. Sorted sets have no
+// expiry here: Compact drops a set once every member of it has expired.
+func (b *BoltDataLimiter) ExtendTTL(ctx context.Context, key string, ttl time.Duration) error {
+	now := time.Now()
+	return b.db.Update(func(tx *bolt.Tx) error {
+		entry, ok := getValue(tx, key, now)
+		if !ok {
+			return nil
+		}
+
+		expiresAt := now.Add(ttl).UnixNano()
+		if entry.expiresAt >= expiresAt {
+			return nil
+		}
+		entry.expiresAt = expiresAt
+		return tx.Bucket(boltValuesBucket).Put([]byte(key), entry.encode())
+	})
+}
+
+// TTL follows the Redis convention: -2 when the key does not exist and -1
+// when it exists without an expiry.
+func (b *BoltDataLimiter) TTL(ctx context.Context, key string) (time.Duration, error) {
+	ttl := time.Duration(-2)
+	now := time.Now()
+	err := b.db.View(func(tx *bolt.Tx) error {
+		if entry, ok := getValue(tx, key, now); ok {
+			if entry.expiresAt == 0 {
+				ttl = time.Duration(-1)
+			} else {
+				ttl = time.Unix(0, entry.expiresAt).Sub(now).Round(time.Second)
+			}
+		} else if set := tx.Bucket(boltSortedSetsBucket).Bucket([]byte(key)); set != nil && bucketLen(set) > 0 {
+			ttl = time.Duration(-1)
+		}
+		return nil
+	})
+	return ttl, err
+}
+
+func (b *BoltDataLimiter) ScanPrefix(ctx context.Context, prefix string) ([]string, error) {
+	var keys []string
+	now := time.Now()
+	err := b.db.View(func(tx *bolt.Tx) error {
+		values := tx.Bucket(boltValuesBucket).Cursor()
+		for k, v := values.Seek([]byte(prefix)); k != nil && strings.HasPrefix(string(k), prefix); k, v = values.Next() {
+			if !decodeBoltEntry(v).expired(now) {
+				keys = append(keys, string(k))
+			}
+		}
+
+		sets := tx.Bucket(boltSortedSetsBucket)
+		cursor := sets.Cursor()
+		for k, _ := cursor.Seek([]byte(prefix)); k != nil && strings.HasPrefix(string(k), prefix); k, _ = cursor.Next() {
+			if set := sets.Bucket(k); set != nil && bucketLen(set) > 0 {
+				keys = append(keys, string(k))
+			}
+		}
+		return nil
+	})
+	return keys, err
+}
+
+// Publish delivers the message to the subscribers of this process only, as
+// the file can't be shared between nodes.
+func (b *BoltDataLimiter) Publish(ctx context.Context, channel, message string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, subscriber := range b.subscribers[channel] {
+		select {
+		case subscriber <- message:
+		default:
+			log.Printf("Dropping message on channel %s: subscriber is full", channel)
+		}
+	}
+	return nil
+}
+
+func (b *BoltDataLimiter) Subscribe(ctx context.Context, channel string) (<-chan string, error) {
+	messages := make(chan string, 64)
+
+	b.mu.Lock()
+	b.subscribers[channel] = append(b.subscribers[channel], messages)
+	b.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+
+		b.mu.Lock()
+		defer b.mu.Unlock()
+
+		subscribers := b.subscribers[channel]
+		for i, subscriber := range subscribers {
+			if subscriber == messages {
+				b.subscribers[channel] = append(subscribers[:i], subscribers[i+1:]...)
+				break
+			}
+		}
+		close(messages)
+	}()
+
+	return messages, nil
+}
+
+// Compact removes the expired keys, the sorted-set members whose score is a
+// Unix time already in the past (the limiter scores each hit with the end of
+// its window) and the sorted sets left empty.
+func (b *BoltDataLimiter) Compact(now time.Time) (int64, error) {
+	var removed int64
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		values := tx.Bucket(boltValuesBucket)
+
+		var expiredKeys [][]byte
+		err := values.ForEach(func(key, raw []byte) error {
+			if decodeBoltEntry(raw).expired(now) {
+				expiredKeys = append(expiredKeys, append([]byte(nil), key...))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, key := range expiredKeys {
+			if err := values.Delete(key); err != nil {
+				return err
+			}
+		}
+		removed += int64(len(expiredKeys))
+
+		sets := tx.Bucket(boltSortedSetsBucket)
+		var setKeys [][]byte
+		err = sets.ForEach(func(key, _ []byte) error {
+			setKeys = append(setKeys, append([]byte(nil), key...))
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, key := range setKeys {
+			set := sets.Bucket(key)
+			if set == nil {
+				continue
+			}
+
+			var expiredMembers [][]byte
+			err := set.ForEach(func(member, score []byte) error {
+				if decodeScore(score) < float64(now.Unix()) {
+					expiredMembers = append(expiredMembers, append([]byte(nil), member...))
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			for _, member := range expiredMembers {
+				if err := set.Delete(member); err != nil {
+					return err
+				}
+			}
+			removed += int64(len(expiredMembers))
+
+			if bucketLen(set) == 0 {
+				if err := sets.DeleteBucket(key); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	return removed, err
+}
+
+// StartCompaction runs Compact at every interval until the context is done.
+// An interval of zero or less disables the compaction.
+func (b *BoltDataLimiter) StartCompaction(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if _, err := b.Compact(now); err != nil {
+				log.Printf("Error compacting bolt datastore: %v", err)
+			}
+		}
+	}
+}
+
+type boltEntry struct {
+	expiresAt int64
+	value     []byte
+}
+
+func (e boltEntry) encode() []byte {
+	raw := make([]byte, 8+len(e.value))
+	binary.BigEndian.PutUint64(raw, uint64(e.expiresAt))
+	copy(raw[8:], e.value)
+	return raw
+}
+
+func (e boltEntry) expired(now time.Time) bool {
+	return e.expiresAt != 0 && now.UnixNano() >= e.expiresAt
+}
+
+func decodeBoltEntry(raw []byte) boltEntry {
+	return boltEntry{
+		expiresAt: int64(binary.BigEndian.Uint64(raw[:8])),
+		value:     append([]byte(nil), raw[8:]...),
+	}
+}
+
+func getValue(tx *bolt.Tx, key string, now time.Time) (boltEntry, bool) {
+	raw := tx.Bucket(boltValuesBucket).Get([]byte(key))
+	if raw == nil {
+		return boltEntry{}, false
+	}
+	entry := decodeBoltEntry(raw)
+	if entry.expired(now) {
+		return boltEntry{}, false
+	}
+	return entry, true
+}
+
+func bucketLen(bucket *bolt.Bucket) int {
+	count := 0
+	c := bucket.Cursor()
+	for k, _ := c.First(); k != nil; k, _ = c.Next() {
+		count++
+	}
+	return count
+}
+
+func encodeScore(score float64) []byte {
+	raw := make([]byte, 8)
+	binary.BigEndian.PutUint64(raw, math.Float64bits(score))
+	return raw
+}
+
+func decodeScore(raw []byte) float64 {
+	return math.Float64frombits(binary.BigEndian.Uint64(raw))
+}
+
+// parseScoreBound reads a score in the Redis syntax: a number, "-inf", "+inf"
+// or a number prefixed by "(" for an exclusive bound.
+func parseScoreBound(bound string) (float64, bool, error) {
+	exclusive := strings.HasPrefix(bound, "(")
+	bound = strings.TrimPrefix(bound, "(")
+
+	switch bound {
+	case "-inf":
+		return math.Inf(-1), exclusive, nil
+	case "+inf", "inf":
+		return math.Inf(1), exclusive, nil
+	}
+
+	score, err := strconv.ParseFloat(bound, 64)
+	if err != nil {
+		return 0, false, fmt.Errorf("invalid score %q: %w", bound, err)
+	}
+	return score, exclusive, nil
+}
+
+func scoreInRange(score, min float64, minExclusive bool, max float64, maxExclusive bool) bool {
+	if score < min || (minExclusive && score == min) {
+		return false
+	}
+	if score > max || (maxExclusive && score == max) {
+		return false
+	}
+	return true
+}
+
+func toBytes(value interface{}) []byte {
+	switch v := value.(type) {
+	case string:
+		return []byte(v)
+	case []byte:
+		return v
+	case nil:
+		return []byte{}
+	default:
+		return []byte(fmt.Sprint(v))
+	}
+}
@@ -0,0 +1,196 @@
+package database
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/jpodlasnisky/ratelimiter/infra/database/contract_db"
+	"github.com/stretchr/testify/assert"
+)
+
+func setupBolt(t *testing.T) (*BoltDataLimiter, string) {
+	path := filepath.Join(t.TempDir(), "ratelimiter.db")
+
+	limiter, err := NewBoltDataLimiter(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { limiter.Close() })
+
+	return limiter, path
+}
+
+func TestBoltZAddZCard(t *testing.T) {
+	limiter, _ := setupBolt(t)
+	ctx := context.Background()
+
+	added, err := limiter.ZAdd(ctx, "key1", &redis.Z{Score: 1, Member: "member1"}, &redis.Z{Score: 2, Member: "member2"})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), added)
+
+	added, err = limiter.ZAdd(ctx, "key1", &redis.Z{Score: 3, Member: "member1"})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), added)
+
+	count, err := limiter.ZCard(ctx, "key1")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), count)
+}
+
+func TestBoltZRemRangeByScore(t *testing.T) {
+	limiter, _ := setupBolt(t)
+	ctx := context.Background()
+
+	_, err := limiter.ZAdd(ctx, "key1",
+		&redis.Z{Score: 1, Member: "member1"},
+		&redis.Z{Score: 2, Member: "member2"},
+		&redis.Z{Score: 3, Member: "member3"},
+	)
+	assert.NoError(t, err)
+
+	removed, err := limiter.ZRemRangeByScore(ctx, "key1", "-inf", "(2")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), removed)
+
+	removed, err = limiter.ZRemRangeByScore(ctx, "key1", "2", "+inf")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), removed)
+
+	_, err = limiter.ZRemRangeByScore(ctx, "key1", "abc", "1")
+	assert.Error(t, err)
+}
+
+func TestBoltGetSetTTL(t *testing.T) {
+	limiter, _ := setupBolt(t)
+	ctx := context.Background()
+
+	_, err := limiter.Get(ctx, "missing")
+	assert.Equal(t, contract_db.ErrNotFound, err)
+
+	assert.NoError(t, limiter.Set(ctx, "key1", []byte("value1"), 0))
+	assert.NoError(t, limiter.SetEX(ctx, "key2", "value2", time.Minute))
+
+	value, err := limiter.Get(ctx, "key1")
+	assert.NoError(t, err)
+	assert.Equal(t, "value1", value)
+
+	ttl, err := limiter.TTL(ctx, "key1")
+	assert.NoError(t, err)
+	assert.Equal(t, time.Duration(-1), ttl)
+
+	ttl, err = limiter.TTL(ctx, "key2")
+	assert.NoError(t, err)
+	assert.Equal(t, time.Minute, ttl)
+
+	ttl, err = limiter.TTL(ctx, "missing")
+	assert.NoError(t, err)
+	assert.Equal(t, time.Duration(-2), ttl)
+}
+
+func TestBoltExpiredKey(t *testing.T) {
+	limiter, _ := setupBolt(t)
+	ctx := context.Background()
+
+	assert.NoError(t, limiter.SetEX(ctx, "key1", "value1", time.Millisecond))
+	time.Sleep(5 * time.Millisecond)
+
+	exists, err := limiter.Exists(ctx, "key1")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), exists)
+
+	_, err = limiter.Get(ctx, "key1")
+	assert.Equal(t, contract_db.ErrNotFound, err)
+}
+
+func TestBoltExistsAndDel(t *testing.T) {
+	limiter, _ := setupBolt(t)
+	ctx := context.Background()
+
+	assert.NoError(t, limiter.Set(ctx, "key1", "value1", 0))
+	_, err := limiter.ZAdd(ctx, "key2", &redis.Z{Score: 1, Member: "member1"})
+	assert.NoError(t, err)
+
+	exists, err := limiter.Exists(ctx, "key1", "key2", "missing")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), exists)
+
+	removed, err := limiter.Del(ctx, "key1", "key2", "missing")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), removed)
+
+	exists, err = limiter.Exists(ctx, "key1", "key2")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), exists)
+}
+
+func TestBoltSurvivesRestart(t *testing.T) {
+	limiter, path := setupBolt(t)
+	ctx := context.Background()
+
+	assert.NoError(t, limiter.SetEX(ctx, "block:key", "", time.Minute))
+	_, err := limiter.ZAdd(ctx, "limiter:key", &redis.Z{Score: 1, Member: "member1"})
+	assert.NoError(t, err)
+	assert.NoError(t, limiter.Close())
+
+	reopened, err := NewBoltDataLimiter(path)
+	assert.NoError(t, err)
+	defer reopened.Close()
+
+	exists, err := reopened.Exists(ctx, "block:key", "limiter:key")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), exists)
+}
+
+func TestBoltCompact(t *testing.T) {
+	limiter, _ := setupBolt(t)
+	ctx := context.Background()
+	now := time.Now()
+
+	assert.NoError(t, limiter.SetEX(ctx, "expired", "", time.Millisecond))
+	assert.NoError(t, limiter.Set(ctx, "kept", "", 0))
+	_, err := limiter.ZAdd(ctx, "window",
+		&redis.Z{Score: float64(now.Add(-time.Minute).Unix()), Member: "old"},
+		&redis.Z{Score: float64(now.Add(time.Minute).Unix()), Member: "new"},
+	)
+	assert.NoError(t, err)
+	_, err = limiter.ZAdd(ctx, "stale", &redis.Z{Score: float64(now.Add(-time.Minute).Unix()), Member: "old"})
+	assert.NoError(t, err)
+
+	removed, err := limiter.Compact(now.Add(time.Second))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), removed)
+
+	count, err := limiter.ZCard(ctx, "window")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+
+	exists, err := limiter.Exists(ctx, "kept", "stale")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), exists)
+}
+
+func TestBoltStartCompaction_Disabled(t *testing.T) {
+	limiter, _ := setupBolt(t)
+
+	// Retorna na hora em vez de entrar em pânico no time.NewTicker
+	limiter.StartCompaction(context.Background(), 0)
+	limiter.StartCompaction(context.Background(), -time.Second)
+}
+
+func TestBoltPublishSubscribe(t *testing.T) {
+	limiter, _ := setupBolt(t)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	messages, err := limiter.Subscribe(ctx, "channel1")
+	assert.NoError(t, err)
+
+	assert.NoError(t, limiter.Publish(ctx, "channel1", "message1"))
+	assert.Equal(t, "message1", <-messages)
+
+	cancel()
+	_, ok := <-messages
+	assert.False(t, ok)
+}
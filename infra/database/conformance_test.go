@@ -0,0 +1,67 @@
+package database
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/jpodlasnisky/ratelimiter/infra/database/contract_db"
+	"github.com/jpodlasnisky/ratelimiter/infra/database/datastoretest"
+)
+
+func TestRedisDataLimiter_Conformance(t *testing.T) {
+	var mr *miniredis.Miniredis
+
+	datastoretest.Run(t, datastoretest.Harness{
+		New: func(t *testing.T) contract_db.Datastore {
+			var err error
+			mr, err = miniredis.Run()
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(mr.Close)
+
+			return NewRedisDataLimiter(redis.NewClient(&redis.Options{Addr: mr.Addr()}))
+		},
+		Advance: func(d time.Duration) { mr.FastForward(d) },
+	})
+}
+
+// TestRedisDataLimiter_ConformanceLive runs the suite against a real Redis
+// when REDIS_TEST_ADDR is set. The database is flushed before each subtest.
+func TestRedisDataLimiter_ConformanceLive(t *testing.T) {
+	addr := os.Getenv("REDIS_TEST_ADDR")
+	if addr == "" {
+		t.Skip("REDIS_TEST_ADDR not set")
+	}
+
+	datastoretest.Run(t, datastoretest.Harness{
+		New: func(t *testing.T) contract_db.Datastore {
+			client := redis.NewClient(&redis.Options{Addr: addr})
+			if err := client.FlushDB(context.Background()).Err(); err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { client.Close() })
+
+			return NewRedisDataLimiter(client)
+		},
+	})
+}
+
+func TestBoltDataLimiter_Conformance(t *testing.T) {
+	datastoretest.Run(t, datastoretest.Harness{
+		New: func(t *testing.T) contract_db.Datastore {
+			limiter, err := NewBoltDataLimiter(filepath.Join(t.TempDir(), "ratelimiter.db"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { limiter.Close() })
+
+			return limiter
+		},
+	})
+}
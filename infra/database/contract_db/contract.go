@@ -17,15 +17,39 @@ type Datastore interface {
 	// This ZAdd method is used to add one or more members to a sorted set, or update its score if it already exists.
 	ZAdd(ctx context.Context, key string, members ...*redis.Z) (int64, error)
 
+	// This ZRem method is used to remove one or more members from a sorted set.
+	ZRem(ctx context.Context, key string, members ...interface{}) (int64, error)
+
+	// This ZRangeWithScores method is used to get the members between two positions of a sorted set, ordered by score.
+	ZRangeWithScores(ctx context.Context, key string, start, stop int64) ([]redis.Z, error)
+
 	// This SetEX method is used to set the value and expiration of a key.
 	SetEX(ctx context.Context, key string, value interface{}, expiration time.Duration) error
 
 	// This Exists method is used to check if a key exists.
 	Exists(ctx context.Context, keys ...string) (int64, error)
 
-	// This Get method is used to get the value of a key.
+	// This Get method is used to get the value of a key. It returns ErrNotFound when the key does not exist.
 	Get(ctx context.Context, key string) (string, error)
 
 	// This Set method is used to set the value of a key.
 	Set(ctx context.Context, key string, value interface{}, expiration time.Duration) error
+
+	// This Del method is used to remove one or more keys.
+	Del(ctx context.Context, keys ...string) (int64, error)
+
+	// This ExtendTTL method is used to make a key expire in ttl, unless it already expires later. A key without expiry gets one.
+	ExtendTTL(ctx context.Context, key string, ttl time.Duration) error
+
+	// This TTL method is used to get the remaining time to live of a key.
+	TTL(ctx context.Context, key string) (time.Duration, error)
+
+	// This Publish method is used to send a message to every subscriber of a channel.
+	Publish(ctx context.Context, channel, message string) error
+
+	// This Subscribe method is used to receive the messages of a channel until the context is done.
+	Subscribe(ctx context.Context, channel string) (<-chan string, error)
+
+	// This ScanPrefix method is used to list the keys, plain or sorted sets, that start with the prefix.
+	ScanPrefix(ctx context.Context, prefix string) ([]string, error)
 }
@@ -0,0 +1,282 @@
+package contract_db
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// blockInvalidationChannel is the channel used to tell every instance that a block was cleared.
+const blockInvalidationChannel = "block:invalidate"
+
+type datastoreStore struct {
+	db Datastore
+}
+
+// NewDatastoreStore implements LimiterStore on top of the Redis-shaped
+// Datastore: the window is a sorted set scored by expiry, the block is a key
+// with a TTL and the policy is a JSON value stored under the token.
+func NewDatastoreStore(db Datastore) LimiterStore {
+	return &datastoreStore{db: db}
+}
+
+// The key of the client goes inside a hash tag so that, on Redis Cluster, the
+// window and the block of the same client always land on the same slot.
+
+func windowKey(key string) string {
+	return "limiter:{" + key + "}"
+}
+
+func blockKey(key string) string {
+	return "block:{" + key + "}"
+}
+
+func (s *datastoreStore) RecordHit(ctx context.Context, key, hitID string, expiresAt, now time.Time) error {
+	_, err := s.db.ZAdd(ctx, windowKey(key), &redis.Z{
+		Score:  unixSeconds(expiresAt),
+		Member: hitID,
+	})
+	if err != nil {
+		return err
+	}
+	return s.expireWindow(ctx, key, expiresAt, now)
+}
+
+// RecordHits adds every hit with a single ZADD, so a cost of thousands, such
+// as the bytes of a message, is still one round trip.
+func (s *datastoreStore) RecordHits(ctx context.Context, key string, hitIDs []string, expiresAt, now time.Time) error {
+	if len(hitIDs) == 0 {
+		return nil
+	}
+
+	score := unixSeconds(expiresAt)
+	members := make([]*redis.Z, len(hitIDs))
+	for i, hitID := range hitIDs {
+		members[i] = &redis.Z{Score: score, Member: hitID}
+	}
+
+	if _, err := s.db.ZAdd(ctx, windowKey(key), members...); err != nil {
+		return err
+	}
+	return s.expireWindow(ctx, key, expiresAt, now)
+}
+
+// expireWindow keeps the window until its last hit expires, so the windows of
+// keys that are never seen again, like random tokens, don't pile up. The ttl
+// is measured from now, so it follows the clock of the limiter.
+func (s *datastoreStore) expireWindow(ctx context.Context, key string, expiresAt, now time.Time) error {
+	ttl := expiresAt.Sub(now)
+	if ttl <= 0 {
+		return nil
+	}
+	// Arredonda para cima: o PEXPIRE só aceita milissegundos inteiros
+	return s.db.ExtendTTL(ctx, windowKey(key), ttl.Truncate(time.Millisecond)+time.Millisecond)
+}
+
+func (s *datastoreStore) CountInWindow(ctx context.Context, key string, now time.Time) (int64, error) {
+	_, err := s.db.ZRemRangeByScore(ctx, windowKey(key), "-inf", strconv.FormatFloat(unixSeconds(now), 'f', -1, 64))
+	if err != nil && !isNotFound(err) {
+		return 0, err
+	}
+
+	count, err := s.db.ZCard(ctx, windowKey(key))
+	if err != nil && !isNotFound(err) {
+		return 0, err
+	}
+	return count, nil
+}
+
+func (s *datastoreStore) RemoveHit(ctx context.Context, key, hitID string) error {
+	_, err := s.db.ZRem(ctx, windowKey(key), hitID)
+	return err
+}
+
+func (s *datastoreStore) RemoveHits(ctx context.Context, key string, hitIDs []string) error {
+	if len(hitIDs) == 0 {
+		return nil
+	}
+
+	members := make([]interface{}, len(hitIDs))
+	for i, hitID := range hitIDs {
+		members[i] = hitID
+	}
+
+	_, err := s.db.ZRem(ctx, windowKey(key), members...)
+	return err
+}
+
+func (s *datastoreStore) HitExpiryAt(ctx context.Context, key string, index int64) (time.Time, error) {
+	hits, err := s.db.ZRangeWithScores(ctx, windowKey(key), index, index)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if len(hits) == 0 {
+		return time.Time{}, ErrNotFound
+	}
+	return scoreTime(hits[0].Score), nil
+}
+
+// scoreTime is the expiry stored as the score of a hit.
+func scoreTime(score float64) time.Time {
+	seconds, fraction := math.Modf(score)
+	return time.Unix(int64(seconds), int64(fraction*float64(time.Second)))
+}
+
+// WeightInWindow reads the whole window, so it suits windows of few weighted
+// hits, like the messages of a stream, rather than one member per request.
+func (s *datastoreStore) WeightInWindow(ctx context.Context, key string, now time.Time) (int64, error) {
+	if _, err := s.CountInWindow(ctx, key, now); err != nil {
+		return 0, err
+	}
+
+	hits, err := s.db.ZRangeWithScores(ctx, windowKey(key), 0, -1)
+	if err != nil && !isNotFound(err) {
+		return 0, err
+	}
+
+	var weight int64
+	for _, hit := range hits {
+		member, _ := hit.Member.(string)
+		weight += HitWeight(member)
+	}
+	return weight, nil
+}
+
+func (s *datastoreStore) WeightExpiryAt(ctx context.Context, key string, weight int64) (time.Time, error) {
+	hits, err := s.db.ZRangeWithScores(ctx, windowKey(key), 0, -1)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	var left int64
+	for _, hit := range hits {
+		member, _ := hit.Member.(string)
+		if left += HitWeight(member); left >= weight {
+			return scoreTime(hit.Score), nil
+		}
+	}
+	return time.Time{}, ErrNotFound
+}
+
+func (s *datastoreStore) Block(ctx context.Context, key string, duration time.Duration) error {
+	return s.db.SetEX(ctx, blockKey(key), "", duration)
+}
+
+func (s *datastoreStore) IsBlocked(ctx context.Context, key string) (bool, time.Duration, error) {
+	exists, err := s.db.Exists(ctx, blockKey(key))
+	if err != nil {
+		return false, 0, err
+	}
+	if exists != 1 {
+		return false, 0, nil
+	}
+
+	ttl, err := s.db.TTL(ctx, blockKey(key))
+	if err != nil {
+		log.Printf("Error reading block TTL for key %s: %v", key, err)
+		return true, 0, nil
+	}
+	if ttl < 0 {
+		ttl = 0
+	}
+	return true, ttl, nil
+}
+
+func (s *datastoreStore) Unblock(ctx context.Context, key string) error {
+	if _, err := s.db.Del(ctx, blockKey(key)); err != nil {
+		return err
+	}
+	return s.db.Publish(ctx, blockInvalidationChannel, key)
+}
+
+func (s *datastoreStore) WatchUnblocks(ctx context.Context) (<-chan string, error) {
+	return s.db.Subscribe(ctx, blockInvalidationChannel)
+}
+
+func (s *datastoreStore) GetPolicy(ctx context.Context, key string) (Policy, error) {
+	value, err := s.db.Get(ctx, key)
+	if isNotFound(err) {
+		return Policy{}, ErrNotFound
+	}
+	if err != nil {
+		return Policy{}, err
+	}
+
+	var policy Policy
+	if err := json.Unmarshal([]byte(value), &policy); err != nil {
+		return Policy{}, err
+	}
+	return policy, nil
+}
+
+func (s *datastoreStore) PutPolicy(ctx context.Context, key string, policy Policy, ttl time.Duration) error {
+	jsonData, err := json.Marshal(policy)
+	if err != nil {
+		return err
+	}
+	return s.db.Set(ctx, key, jsonData, ttl)
+}
+
+// unixSeconds is the score of a hit: seconds since the epoch, with the
+// fraction, so windows shorter than a second keep their precision.
+func unixSeconds(t time.Time) float64 {
+	return float64(t.UnixNano()) / float64(time.Second)
+}
+
+// isNotFound accepts redis.Nil too, for Datastore implementations that still
+// return the Redis error.
+func isNotFound(err error) bool {
+	return errors.Is(err, ErrNotFound) || errors.Is(err, redis.Nil)
+}
+
+func (s *datastoreStore) Purge(ctx context.Context, key string) error {
+	if _, err := s.db.Del(ctx, key, windowKey(key), blockKey(key)); err != nil {
+		return err
+	}
+	return s.db.Publish(ctx, blockInvalidationChannel, key)
+}
+
+func (s *datastoreStore) Reset(ctx context.Context, key string) error {
+	if _, err := s.db.Del(ctx, windowKey(key), blockKey(key)); err != nil {
+		return err
+	}
+	return s.db.Publish(ctx, blockInvalidationChannel, key)
+}
+
+func (s *datastoreStore) Keys(ctx context.Context, prefix string) ([]string, error) {
+	seen := make(map[string]bool)
+
+	// As políticas ficam na própria chave; janelas e bloqueios, dentro da hash tag.
+	for _, wrapper := range []string{"", "limiter:{", "block:{"} {
+		found, err := s.db.ScanPrefix(ctx, wrapper+prefix)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, key := range found {
+			if wrapper == "" {
+				if strings.HasPrefix(key, "limiter:{") || strings.HasPrefix(key, "block:{") {
+					continue
+				}
+				seen[key] = true
+			} else if strings.HasSuffix(key, "}") {
+				seen[strings.TrimSuffix(strings.TrimPrefix(key, wrapper), "}")] = true
+			}
+		}
+	}
+
+	keys := make([]string, 0, len(seen))
+	for key := range seen {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
@@ -0,0 +1,191 @@
+package contract_db_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/jpodlasnisky/ratelimiter/infra/database"
+	"github.com/jpodlasnisky/ratelimiter/infra/database/contract_db"
+	"github.com/stretchr/testify/assert"
+)
+
+func setup(t *testing.T) (contract_db.LimiterStore, *miniredis.Miniredis) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(mr.Close)
+
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	return contract_db.NewDatastoreStore(database.NewRedisDataLimiter(client)), mr
+}
+
+func TestDatastoreStore_Window(t *testing.T) {
+	store, _ := setup(t)
+	ctx := context.Background()
+	now := time.Now()
+
+	assert.NoError(t, store.RecordHit(ctx, "key", "hit1", now.Add(-time.Second), now))
+	assert.NoError(t, store.RecordHit(ctx, "key", "hit2", now.Add(time.Minute), now))
+
+	count, err := store.CountInWindow(ctx, "key", now)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+}
+
+func TestDatastoreStore_WindowExpires(t *testing.T) {
+	store, mr := setup(t)
+	ctx := context.Background()
+	now := time.Now()
+
+	assert.NoError(t, store.RecordHit(ctx, "key", "hit1", now.Add(time.Minute), now))
+	assert.NoError(t, store.RecordHits(ctx, "key", []string{"hit2", "hit3"}, now.Add(10*time.Second), now))
+
+	ttl := mr.TTL("limiter:{key}")
+	assert.True(t, ttl > 50*time.Second && ttl <= time.Minute+time.Millisecond, "the window must live until its last hit expires, got %s", ttl)
+
+	mr.FastForward(time.Minute + time.Second)
+	assert.False(t, mr.Exists("limiter:{key}"), "the window of a key never seen again must not pile up")
+}
+
+func TestDatastoreStore_WindowExpiresWithLimiterClock(t *testing.T) {
+	store, mr := setup(t)
+	ctx := context.Background()
+	now := time.Now().Add(-time.Hour)
+
+	assert.NoError(t, store.RecordHit(ctx, "key", "hit1", now.Add(time.Minute), now))
+
+	ttl := mr.TTL("limiter:{key}")
+	assert.True(t, ttl > 50*time.Second && ttl <= time.Minute+time.Millisecond, "the ttl must be measured from the clock of the limiter, got %s", ttl)
+}
+
+func TestDatastoreStore_HitExpiryAndRemove(t *testing.T) {
+	store, _ := setup(t)
+	ctx := context.Background()
+	now := time.Unix(1700000000, 0)
+
+	assert.NoError(t, store.RecordHit(ctx, "key", "late", now.Add(2*time.Second), now))
+	assert.NoError(t, store.RecordHit(ctx, "key", "early", now.Add(time.Second), now))
+
+	expiry, err := store.HitExpiryAt(ctx, "key", 0)
+	assert.NoError(t, err)
+	assert.Equal(t, now.Add(time.Second), expiry)
+
+	_, err = store.HitExpiryAt(ctx, "key", 2)
+	assert.ErrorIs(t, err, contract_db.ErrNotFound)
+
+	assert.NoError(t, store.RemoveHit(ctx, "key", "early"))
+	expiry, err = store.HitExpiryAt(ctx, "key", 0)
+	assert.NoError(t, err)
+	assert.Equal(t, now.Add(2*time.Second), expiry)
+}
+
+func TestDatastoreStore_WeightedHits(t *testing.T) {
+	store, _ := setup(t)
+	ctx := context.Background()
+	now := time.Unix(1700000000, 0)
+
+	assert.NoError(t, store.RecordHit(ctx, "key", contract_db.WeightedHitID("small", 10), now.Add(time.Second), now))
+	assert.NoError(t, store.RecordHit(ctx, "key", contract_db.WeightedHitID("large", 1<<20), now.Add(2*time.Second), now))
+	assert.NoError(t, store.RecordHit(ctx, "key", "plain", now.Add(3*time.Second), now))
+
+	weight, err := store.WeightInWindow(ctx, "key", now)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1<<20+11), weight)
+
+	expiry, err := store.WeightExpiryAt(ctx, "key", 11)
+	assert.NoError(t, err)
+	assert.Equal(t, now.Add(2*time.Second), expiry, "the large hit must leave before 11 of weight is free")
+
+	_, err = store.WeightExpiryAt(ctx, "key", 1<<21)
+	assert.ErrorIs(t, err, contract_db.ErrNotFound)
+
+	weight, err = store.WeightInWindow(ctx, "key", now.Add(2*time.Second))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), weight)
+}
+
+func TestDatastoreStore_Block(t *testing.T) {
+	store, mr := setup(t)
+	ctx := context.Background()
+
+	blocked, _, err := store.IsBlocked(ctx, "key")
+	assert.NoError(t, err)
+	assert.False(t, blocked)
+
+	assert.NoError(t, store.Block(ctx, "key", time.Minute))
+	assert.True(t, mr.Exists("block:{key}"))
+
+	blocked, remaining, err := store.IsBlocked(ctx, "key")
+	assert.NoError(t, err)
+	assert.True(t, blocked)
+	assert.Equal(t, time.Minute, remaining)
+
+	assert.NoError(t, store.Unblock(ctx, "key"))
+	blocked, _, err = store.IsBlocked(ctx, "key")
+	assert.NoError(t, err)
+	assert.False(t, blocked)
+}
+
+func TestDatastoreStore_Policy(t *testing.T) {
+	store, mr := setup(t)
+	ctx := context.Background()
+
+	_, err := store.GetPolicy(ctx, "token")
+	assert.ErrorIs(t, err, contract_db.ErrNotFound)
+
+	assert.NoError(t, store.PutPolicy(ctx, "token", contract_db.Policy{Token: "token", LimitReq: 5}, 0))
+
+	value, err := mr.Get("token")
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"token":"token","limitReq":5}`, value)
+
+	policy, err := store.GetPolicy(ctx, "token")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(5), policy.LimitReq)
+}
+
+func TestDatastoreStore_Purge(t *testing.T) {
+	store, mr := setup(t)
+	ctx := context.Background()
+
+	assert.NoError(t, store.RecordHit(ctx, "key", "hit1", time.Now().Add(time.Minute), time.Now()))
+	assert.NoError(t, store.Block(ctx, "key", time.Minute))
+	assert.NoError(t, store.PutPolicy(ctx, "key", contract_db.Policy{LimitReq: 2}, 0))
+	assert.NoError(t, store.RecordHit(ctx, "other", "hit1", time.Now().Add(time.Minute), time.Now()))
+
+	assert.NoError(t, store.Purge(ctx, "key"))
+
+	assert.Equal(t, []string{"limiter:{other}"}, mr.Keys())
+}
+
+func TestDatastoreStore_Reset(t *testing.T) {
+	store, mr := setup(t)
+	ctx := context.Background()
+
+	assert.NoError(t, store.RecordHit(ctx, "key", "hit1", time.Now().Add(time.Minute), time.Now()))
+	assert.NoError(t, store.Block(ctx, "key", time.Minute))
+	assert.NoError(t, store.PutPolicy(ctx, "key", contract_db.Policy{LimitReq: 2}, 0))
+
+	assert.NoError(t, store.Reset(ctx, "key"))
+
+	assert.Equal(t, []string{"key"}, mr.Keys(), "the policy must be kept")
+}
+
+func TestDatastoreStore_Keys(t *testing.T) {
+	store, _ := setup(t)
+	ctx := context.Background()
+
+	assert.NoError(t, store.RecordHit(ctx, "prod:app:1.2.3.4", "hit1", time.Now().Add(time.Minute), time.Now()))
+	assert.NoError(t, store.Block(ctx, "prod:app:1.2.3.4", time.Minute))
+	assert.NoError(t, store.PutPolicy(ctx, "prod:app:TOKEN_1", contract_db.Policy{LimitReq: 2}, 0))
+	assert.NoError(t, store.Block(ctx, "prod:app:TOKEN_2", time.Minute))
+	assert.NoError(t, store.PutPolicy(ctx, "staging:app:TOKEN_1", contract_db.Policy{LimitReq: 2}, 0))
+
+	keys, err := store.Keys(ctx, "prod:app:")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"prod:app:1.2.3.4", "prod:app:TOKEN_1", "prod:app:TOKEN_2"}, keys)
+}
@@ -0,0 +1,6 @@
+package contract_db
+
+import "errors"
+
+// ErrNotFound is returned by every backend when the requested key does not exist.
+var ErrNotFound = errors.New("key not found")
@@ -0,0 +1,92 @@
+package contract_db
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Policy is the limit registered for a token.
+type Policy struct {
+	Token    string `json:"token,omitempty"`
+	LimitReq int64  `json:"limitReq"`
+}
+
+// WeightedHitID names a hit that counts as weight hits, such as the bytes of
+// a message, so a single member carries the whole cost.
+func WeightedHitID(hitID string, weight int64) string {
+	return hitID + "*" + strconv.FormatInt(weight, 10)
+}
+
+// HitWeight returns how many hits the hit counts as: the weight given to
+// WeightedHitID, or 1 for any other hit.
+func HitWeight(hitID string) int64 {
+	i := strings.LastIndexByte(hitID, '*')
+	if i < 0 {
+		return 1
+	}
+	weight, err := strconv.ParseInt(hitID[i+1:], 10, 64)
+	if err != nil || weight < 0 {
+		return 1
+	}
+	return weight
+}
+
+// LimiterStore describes the state kept by the rate limiter without assuming
+// how a backend stores it.
+type LimiterStore interface {
+	// This RecordHit method is used to add a hit to the window of a key, counted until expiresAt.
+	// The window is kept until expiresAt as seen from now, the time of the limiter's clock.
+	RecordHit(ctx context.Context, key, hitID string, expiresAt, now time.Time) error
+
+	// This RecordHits method is used to add several hits to the window of a key at once, all counted until expiresAt.
+	RecordHits(ctx context.Context, key string, hitIDs []string, expiresAt, now time.Time) error
+
+	// This CountInWindow method is used to discard the hits that expired by now and count the remaining ones.
+	CountInWindow(ctx context.Context, key string, now time.Time) (int64, error)
+
+	// This RemoveHit method is used to take back a hit recorded by RecordHit.
+	RemoveHit(ctx context.Context, key, hitID string) error
+
+	// This RemoveHits method is used to take back several hits recorded by RecordHits at once.
+	RemoveHits(ctx context.Context, key string, hitIDs []string) error
+
+	// This HitExpiryAt method is used to get when the hit at the given position, ordered by expiry, leaves the window.
+	// It returns ErrNotFound when the window has fewer hits.
+	HitExpiryAt(ctx context.Context, key string, index int64) (time.Time, error)
+
+	// This WeightInWindow method is used to discard the hits that expired by now and sum the weights of the remaining ones.
+	WeightInWindow(ctx context.Context, key string, now time.Time) (int64, error)
+
+	// This WeightExpiryAt method is used to get when the hits that expire first, weighing at least weight together, have left the window.
+	// It returns ErrNotFound when the window weighs less.
+	WeightExpiryAt(ctx context.Context, key string, weight int64) (time.Time, error)
+
+	// This Block method is used to block a key for the given duration.
+	Block(ctx context.Context, key string, duration time.Duration) error
+
+	// This IsBlocked method is used to check if a key is blocked and for how long, when known.
+	IsBlocked(ctx context.Context, key string) (bool, time.Duration, error)
+
+	// This Unblock method is used to clear the block of a key and notify every WatchUnblocks.
+	Unblock(ctx context.Context, key string) error
+
+	// This WatchUnblocks method is used to receive the keys unblocked by any instance until the context is done.
+	WatchUnblocks(ctx context.Context) (<-chan string, error)
+
+	// This GetPolicy method is used to get the policy of a key. It returns ErrNotFound when there is none.
+	GetPolicy(ctx context.Context, key string) (Policy, error)
+
+	// This PutPolicy method is used to store the policy of a key. A zero ttl keeps it forever.
+	PutPolicy(ctx context.Context, key string, policy Policy, ttl time.Duration) error
+
+	// This Purge method is used to delete the window, the block and the policy of a key.
+	Purge(ctx context.Context, key string) error
+
+	// This Reset method is used to delete the window and the block of a key, keeping its policy.
+	Reset(ctx context.Context, key string) error
+
+	// This Keys method is used to list the keys that start with the prefix and have a window, a block or a policy.
+	Keys(ctx context.Context, prefix string) ([]string, error)
+}
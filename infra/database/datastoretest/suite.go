@@ -0,0 +1,383 @@
+// Package datastoretest provides a conformance suite that every
+// contract_db.Datastore implementation is expected to pass.
+package datastoretest
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/jpodlasnisky/ratelimiter/infra/database/contract_db"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Harness tells the suite how to get a clean Datastore and how to move its
+// clock forward.
+type Harness struct {
+	// New returns an empty Datastore. It is called once per subtest.
+	New func(t *testing.T) contract_db.Datastore
+
+	// Advance moves the clock of the Datastore forward, so the TTL tests don't
+	// need to sleep. When nil the suite sleeps for the given duration.
+	Advance func(d time.Duration)
+}
+
+// Run runs every conformance test against the Datastore built by the harness.
+func Run(t *testing.T, h Harness) {
+	if h.Advance == nil {
+		h.Advance = time.Sleep
+	}
+
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, h Harness)
+	}{
+		{"SortedSet", testSortedSet},
+		{"SortedSetScoreBounds", testSortedSetScoreBounds},
+		{"SortedSetRangeAndRem", testSortedSetRangeAndRem},
+		{"GetSet", testGetSet},
+		{"MissingKey", testMissingKey},
+		{"TTLExpiry", testTTLExpiry},
+		{"ExtendTTL", testExtendTTL},
+		{"ExistsMultipleKeys", testExistsMultipleKeys},
+		{"Del", testDel},
+		{"ScanPrefix", testScanPrefix},
+		{"PublishSubscribe", testPublishSubscribe},
+		{"ConcurrentWriters", testConcurrentWriters},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.fn(t, h)
+		})
+	}
+}
+
+func testSortedSet(t *testing.T, h Harness) {
+	db := h.New(t)
+	ctx := context.Background()
+
+	added, err := db.ZAdd(ctx, "zset", &redis.Z{Score: 1, Member: "a"}, &redis.Z{Score: 2, Member: "b"})
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), added, "ZAdd must count only the new members")
+
+	added, err = db.ZAdd(ctx, "zset", &redis.Z{Score: 5, Member: "a"}, &redis.Z{Score: 3, Member: "c"})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), added, "ZAdd must update the score of an existing member without counting it")
+
+	count, err := db.ZCard(ctx, "zset")
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), count)
+
+	removed, err := db.ZRemRangeByScore(ctx, "zset", "-inf", "3")
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), removed, "the updated score of a must keep it out of the range")
+
+	count, err = db.ZCard(ctx, "zset")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+
+	count, err = db.ZCard(ctx, "missing")
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), count)
+
+	removed, err = db.ZRemRangeByScore(ctx, "missing", "-inf", "+inf")
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), removed)
+}
+
+func testSortedSetScoreBounds(t *testing.T, h Harness) {
+	db := h.New(t)
+	ctx := context.Background()
+
+	_, err := db.ZAdd(ctx, "zset",
+		&redis.Z{Score: 1, Member: "a"},
+		&redis.Z{Score: 2, Member: "b"},
+		&redis.Z{Score: 3, Member: "c"},
+		&redis.Z{Score: 4, Member: "d"},
+	)
+	require.NoError(t, err)
+
+	removed, err := db.ZRemRangeByScore(ctx, "zset", "(1", "(3")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), removed, "exclusive bounds must keep the members on the edges")
+
+	removed, err = db.ZRemRangeByScore(ctx, "zset", "3", "+inf")
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), removed, "inclusive bounds must remove the members on the edges")
+
+	count, err := db.ZCard(ctx, "zset")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+}
+
+func testSortedSetRangeAndRem(t *testing.T, h Harness) {
+	db := h.New(t)
+	ctx := context.Background()
+
+	_, err := db.ZAdd(ctx, "zset",
+		&redis.Z{Score: 3, Member: "c"},
+		&redis.Z{Score: 1, Member: "a"},
+		&redis.Z{Score: 2, Member: "b"},
+	)
+	require.NoError(t, err)
+
+	members, err := db.ZRangeWithScores(ctx, "zset", 0, -1)
+	require.NoError(t, err)
+	assert.Equal(t, []redis.Z{{Score: 1, Member: "a"}, {Score: 2, Member: "b"}, {Score: 3, Member: "c"}}, members, "members must be ordered by score")
+
+	members, err = db.ZRangeWithScores(ctx, "zset", 1, 1)
+	require.NoError(t, err)
+	assert.Equal(t, []redis.Z{{Score: 2, Member: "b"}}, members)
+
+	members, err = db.ZRangeWithScores(ctx, "zset", 5, 10)
+	require.NoError(t, err)
+	assert.Empty(t, members)
+
+	removed, err := db.ZRem(ctx, "zset", "a", "missing")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), removed)
+
+	members, err = db.ZRangeWithScores(ctx, "zset", 0, 0)
+	require.NoError(t, err)
+	assert.Equal(t, []redis.Z{{Score: 2, Member: "b"}}, members)
+
+	members, err = db.ZRangeWithScores(ctx, "missing", 0, -1)
+	require.NoError(t, err)
+	assert.Empty(t, members)
+}
+
+func testGetSet(t *testing.T, h Harness) {
+	db := h.New(t)
+	ctx := context.Background()
+
+	require.NoError(t, db.Set(ctx, "string", "value", 0))
+	require.NoError(t, db.Set(ctx, "bytes", []byte(`{"limitReq":2}`), 0))
+
+	value, err := db.Get(ctx, "string")
+	require.NoError(t, err)
+	assert.Equal(t, "value", value)
+
+	value, err = db.Get(ctx, "bytes")
+	require.NoError(t, err)
+	assert.Equal(t, `{"limitReq":2}`, value)
+
+	require.NoError(t, db.Set(ctx, "string", "other", 0))
+	value, err = db.Get(ctx, "string")
+	require.NoError(t, err)
+	assert.Equal(t, "other", value, "Set must overwrite the previous value")
+}
+
+func testMissingKey(t *testing.T, h Harness) {
+	db := h.New(t)
+	ctx := context.Background()
+
+	_, err := db.Get(ctx, "missing")
+	assert.ErrorIs(t, err, contract_db.ErrNotFound)
+
+	ttl, err := db.TTL(ctx, "missing")
+	require.NoError(t, err)
+	assert.Equal(t, time.Duration(-2), ttl)
+
+	exists, err := db.Exists(ctx, "missing")
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), exists)
+
+	removed, err := db.Del(ctx, "missing")
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), removed)
+}
+
+func testTTLExpiry(t *testing.T, h Harness) {
+	db := h.New(t)
+	ctx := context.Background()
+
+	require.NoError(t, db.Set(ctx, "persistent", "value", 0))
+	require.NoError(t, db.SetEX(ctx, "expiring", "value", time.Second))
+
+	ttl, err := db.TTL(ctx, "persistent")
+	require.NoError(t, err)
+	assert.Equal(t, time.Duration(-1), ttl)
+
+	ttl, err = db.TTL(ctx, "expiring")
+	require.NoError(t, err)
+	assert.True(t, ttl >= 0 && ttl <= time.Second, "unexpected TTL %s", ttl)
+
+	h.Advance(1100 * time.Millisecond)
+
+	exists, err := db.Exists(ctx, "persistent", "expiring")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), exists)
+
+	_, err = db.Get(ctx, "expiring")
+	assert.ErrorIs(t, err, contract_db.ErrNotFound)
+
+	ttl, err = db.TTL(ctx, "expiring")
+	require.NoError(t, err)
+	assert.Equal(t, time.Duration(-2), ttl)
+}
+
+func testExtendTTL(t *testing.T, h Harness) {
+	db := h.New(t)
+	ctx := context.Background()
+
+	require.NoError(t, db.Set(ctx, "persistent", "value", 0))
+	require.NoError(t, db.SetEX(ctx, "short", "value", time.Second))
+	require.NoError(t, db.SetEX(ctx, "long", "value", time.Hour))
+
+	for _, key := range []string{"persistent", "short", "long", "missing"} {
+		require.NoError(t, db.ExtendTTL(ctx, key, time.Minute))
+	}
+
+	ttl, err := db.TTL(ctx, "persistent")
+	require.NoError(t, err)
+	assert.True(t, ttl > 0 && ttl <= time.Minute, "a key without expiry must get one, got %s", ttl)
+
+	ttl, err = db.TTL(ctx, "short")
+	require.NoError(t, err)
+	assert.True(t, ttl > time.Second && ttl <= time.Minute, "the expiry must be pushed forward, got %s", ttl)
+
+	ttl, err = db.TTL(ctx, "long")
+	require.NoError(t, err)
+	assert.True(t, ttl > time.Minute, "a later expiry must be kept, got %s", ttl)
+
+	exists, err := db.Exists(ctx, "missing")
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), exists, "extending a missing key must not create it")
+}
+
+func testExistsMultipleKeys(t *testing.T, h Harness) {
+	db := h.New(t)
+	ctx := context.Background()
+
+	require.NoError(t, db.Set(ctx, "key1", "value", 0))
+	require.NoError(t, db.SetEX(ctx, "key2", "", time.Minute))
+	_, err := db.ZAdd(ctx, "zset", &redis.Z{Score: 1, Member: "a"})
+	require.NoError(t, err)
+
+	exists, err := db.Exists(ctx, "key1", "key2", "zset", "missing")
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), exists)
+
+	exists, err = db.Exists(ctx, "key1", "key1")
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), exists, "a key repeated in Exists must be counted every time")
+}
+
+func testDel(t *testing.T, h Harness) {
+	db := h.New(t)
+	ctx := context.Background()
+
+	require.NoError(t, db.Set(ctx, "key1", "value", 0))
+	_, err := db.ZAdd(ctx, "zset", &redis.Z{Score: 1, Member: "a"})
+	require.NoError(t, err)
+
+	removed, err := db.Del(ctx, "key1", "zset", "missing")
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), removed)
+
+	exists, err := db.Exists(ctx, "key1", "zset")
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), exists)
+
+	count, err := db.ZCard(ctx, "zset")
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), count)
+}
+
+func testScanPrefix(t *testing.T, h Harness) {
+	db := h.New(t)
+	ctx := context.Background()
+
+	require.NoError(t, db.Set(ctx, "ns:a", "value", 0))
+	_, err := db.ZAdd(ctx, "ns:b", &redis.Z{Score: 1, Member: "a"})
+	require.NoError(t, err)
+	require.NoError(t, db.Set(ctx, "other:ns:c", "value", 0))
+	require.NoError(t, db.Set(ctx, "n?:d", "value", 0))
+
+	keys, err := db.ScanPrefix(ctx, "ns:")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"ns:a", "ns:b"}, keys)
+
+	keys, err = db.ScanPrefix(ctx, "n?:")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"n?:d"}, keys, "the prefix must not be read as a pattern")
+
+	keys, err = db.ScanPrefix(ctx, "missing:")
+	require.NoError(t, err)
+	assert.Empty(t, keys)
+}
+
+func testPublishSubscribe(t *testing.T, h Harness) {
+	db := h.New(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	messages, err := db.Subscribe(ctx, "channel")
+	require.NoError(t, err)
+
+	require.NoError(t, db.Publish(ctx, "other", "ignored"))
+	require.NoError(t, db.Publish(ctx, "channel", "message"))
+
+	select {
+	case msg := <-messages:
+		assert.Equal(t, "message", msg)
+	case <-time.After(2 * time.Second):
+		t.Fatal("message not received")
+	}
+
+	cancel()
+	select {
+	case _, ok := <-messages:
+		for ok {
+			_, ok = <-messages
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("channel not closed after the context was cancelled")
+	}
+}
+
+func testConcurrentWriters(t *testing.T, h Harness) {
+	db := h.New(t)
+	ctx := context.Background()
+
+	const writers = 10
+	const hitsPerWriter = 20
+
+	var wg sync.WaitGroup
+	errs := make(chan error, writers*hitsPerWriter*2)
+
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < hitsPerWriter; i++ {
+				member := fmt.Sprintf("%d-%d", w, i)
+				if _, err := db.ZAdd(ctx, "zset", &redis.Z{Score: float64(i), Member: member}); err != nil {
+					errs <- err
+				}
+				if err := db.Set(ctx, "key:"+member, member, 0); err != nil {
+					errs <- err
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		require.NoError(t, err)
+	}
+
+	count, err := db.ZCard(ctx, "zset")
+	require.NoError(t, err)
+	assert.Equal(t, int64(writers*hitsPerWriter), count)
+
+	value, err := db.Get(ctx, "key:3-7")
+	require.NoError(t, err)
+	assert.Equal(t, "3-7", value)
+}
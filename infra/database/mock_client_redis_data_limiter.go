@@ -27,6 +27,17 @@ func (m *MockRedisClient) ZAdd(ctx context.Context, key string, members ...*redi
 	return args.Get(0).(int64), args.Error(1)
 }
 
+func (m *MockRedisClient) ZRem(ctx context.Context, key string, members ...interface{}) (int64, error) {
+	args := m.Called(ctx, key, members)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *MockRedisClient) ZRangeWithScores(ctx context.Context, key string, start, stop int64) ([]redis.Z, error) {
+	args := m.Called(ctx, key, start, stop)
+	members, _ := args.Get(0).([]redis.Z)
+	return members, args.Error(1)
+}
+
 func (m *MockRedisClient) SetEX(ctx context.Context, key string, value interface{}, expiration time.Duration) error {
 	args := m.Called(ctx, key, value, expiration)
 	return args.Error(0)
@@ -46,3 +57,35 @@ func (m *MockRedisClient) Set(ctx context.Context, key string, value interface{}
 	args := m.Called(ctx, key, value, expiration)
 	return args.Error(0)
 }
+
+func (m *MockRedisClient) Del(ctx context.Context, keys ...string) (int64, error) {
+	args := m.Called(ctx, keys)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *MockRedisClient) ExtendTTL(ctx context.Context, key string, ttl time.Duration) error {
+	args := m.Called(ctx, key, ttl)
+	return args.Error(0)
+}
+
+func (m *MockRedisClient) TTL(ctx context.Context, key string) (time.Duration, error) {
+	args := m.Called(ctx, key)
+	return args.Get(0).(time.Duration), args.Error(1)
+}
+
+func (m *MockRedisClient) Publish(ctx context.Context, channel, message string) error {
+	args := m.Called(ctx, channel, message)
+	return args.Error(0)
+}
+
+func (m *MockRedisClient) Subscribe(ctx context.Context, channel string) (<-chan string, error) {
+	args := m.Called(ctx, channel)
+	messages, _ := args.Get(0).(chan string)
+	return messages, args.Error(1)
+}
+
+func (m *MockRedisClient) ScanPrefix(ctx context.Context, prefix string) ([]string, error) {
+	args := m.Called(ctx, prefix)
+	keys, _ := args.Get(0).([]string)
+	return keys, args.Error(1)
+}
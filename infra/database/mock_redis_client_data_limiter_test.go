@@ -92,3 +92,70 @@ func TestSetMock(t *testing.T) {
 
 	mockClient.AssertExpectations(t)
 }
+
+func TestDelMock(t *testing.T) {
+	mockClient := new(MockRedisClient)
+	mockClient.On("Del", mock.Anything, []string{"key1"}).Return(int64(1), nil)
+
+	removed, err := mockClient.Del(context.Background(), "key1")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), removed)
+
+	mockClient.AssertExpectations(t)
+}
+
+func TestTTLMock(t *testing.T) {
+	mockClient := new(MockRedisClient)
+	mockClient.On("TTL", mock.Anything, "key1").Return(time.Minute, nil)
+
+	ttl, err := mockClient.TTL(context.Background(), "key1")
+	assert.NoError(t, err)
+	assert.Equal(t, time.Minute, ttl)
+
+	mockClient.AssertExpectations(t)
+}
+
+func TestPublishMock(t *testing.T) {
+	mockClient := new(MockRedisClient)
+	mockClient.On("Publish", mock.Anything, "channel1", "message1").Return(nil)
+
+	err := mockClient.Publish(context.Background(), "channel1", "message1")
+	assert.NoError(t, err)
+
+	mockClient.AssertExpectations(t)
+}
+
+func TestSubscribeMock(t *testing.T) {
+	mockClient := new(MockRedisClient)
+	messages := make(chan string)
+	mockClient.On("Subscribe", mock.Anything, "channel1").Return(messages, nil)
+
+	received, err := mockClient.Subscribe(context.Background(), "channel1")
+	assert.NoError(t, err)
+	assert.NotNil(t, received)
+
+	mockClient.AssertExpectations(t)
+}
+
+func TestZRemMock(t *testing.T) {
+	mockClient := new(MockRedisClient)
+	mockClient.On("ZRem", mock.Anything, "key1", []interface{}{"member1"}).Return(int64(1), nil)
+
+	removed, err := mockClient.ZRem(context.Background(), "key1", "member1")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), removed)
+
+	mockClient.AssertExpectations(t)
+}
+
+func TestZRangeWithScoresMock(t *testing.T) {
+	mockClient := new(MockRedisClient)
+	members := []redis.Z{{Score: 1, Member: "member1"}}
+	mockClient.On("ZRangeWithScores", mock.Anything, "key1", int64(0), int64(0)).Return(members, nil)
+
+	result, err := mockClient.ZRangeWithScores(context.Background(), "key1", 0, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, members, result)
+
+	mockClient.AssertExpectations(t)
+}
@@ -2,25 +2,45 @@ package database
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/jpodlasnisky/ratelimiter/config"
+	"github.com/jpodlasnisky/ratelimiter/infra/database/contract_db"
 	"github.com/go-redis/redis/v8"
 )
 
-func NewRedisClient(config *config.Config) *redis.Client {
-	return redis.NewClient(&redis.Options{
-		Addr:     config.RedisURL,
-		Password: "",
-		DB:       0,
-	})
+// NewRedisClient builds a single-node, Sentinel or Cluster client depending on
+// REDIS_MODE.
+func NewRedisClient(config *config.Config) (redis.UniversalClient, error) {
+	opts, err := NewRedisUniversalOptions(config)
+	if err != nil {
+		return nil, err
+	}
+
+	switch config.RedisMode {
+	case "", RedisModeSingle:
+		return redis.NewClient(opts.Simple()), nil
+	case RedisModeSentinel:
+		if opts.MasterName == "" {
+			return nil, errors.New("REDIS_MASTER_NAME is required in sentinel mode")
+		}
+		return redis.NewFailoverClient(opts.Failover()), nil
+	case RedisModeCluster:
+		return redis.NewClusterClient(opts.Cluster()), nil
+	default:
+		return nil, fmt.Errorf("invalid REDIS_MODE: %s", config.RedisMode)
+	}
 }
 
 type RedisDataLimiter struct {
-	client *redis.Client
+	client redis.UniversalClient
 }
 
-func NewRedisDataLimiter(client *redis.Client) *RedisDataLimiter {
+func NewRedisDataLimiter(client redis.UniversalClient) *RedisDataLimiter {
 	return &RedisDataLimiter{client: client}
 }
 
@@ -36,6 +56,14 @@ func (r *RedisDataLimiter) ZAdd(ctx context.Context, key string, members ...*red
 	return r.client.ZAdd(ctx, key, members...).Result()
 }
 
+func (r *RedisDataLimiter) ZRem(ctx context.Context, key string, members ...interface{}) (int64, error) {
+	return r.client.ZRem(ctx, key, members...).Result()
+}
+
+func (r *RedisDataLimiter) ZRangeWithScores(ctx context.Context, key string, start, stop int64) ([]redis.Z, error) {
+	return r.client.ZRangeWithScores(ctx, key, start, stop).Result()
+}
+
 func (r *RedisDataLimiter) SetEX(ctx context.Context, key string, value interface{}, expiration time.Duration) error {
 	return r.client.SetEX(ctx, key, value, expiration).Err()
 }
@@ -45,9 +73,108 @@ func (r *RedisDataLimiter) Exists(ctx context.Context, keys ...string) (int64, e
 }
 
 func (r *RedisDataLimiter) Get(ctx context.Context, key string) (string, error) {
-	return r.client.Get(ctx, key).Result()
+	value, err := r.client.Get(ctx, key).Result()
+	if err == redis.Nil {
+		return "", contract_db.ErrNotFound
+	}
+	return value, err
 }
 
 func (r *RedisDataLimiter) Set(ctx context.Context, key string, value interface{}, expiration time.Duration) error {
 	return r.client.Set(ctx, key, value, expiration).Err()
 }
+
+func (r *RedisDataLimiter) Del(ctx context.Context, keys ...string) (int64, error) {
+	return r.client.Del(ctx, keys...).Result()
+}
+
+// extendTTLScript only pushes the expiry forward, so a window holding hits of
+// different lengths lives until its last hit expires.
+var extendTTLScript = redis.NewScript(`
+local pttl = redis.call('PTTL', KEYS[1])
+if pttl == -2 or pttl >= tonumber(ARGV[1]) then
+	return 0
+end
+return redis.call('PEXPIRE', KEYS[1], ARGV[1])
+`)
+
+func (r *RedisDataLimiter) ExtendTTL(ctx context.Context, key string, ttl time.Duration) error {
+	return extendTTLScript.Run(ctx, r.client, []string{key}, ttl.Milliseconds()).Err()
+}
+
+func (r *RedisDataLimiter) TTL(ctx context.Context, key string) (time.Duration, error) {
+	return r.client.TTL(ctx, key).Result()
+}
+
+func (r *RedisDataLimiter) Publish(ctx context.Context, channel, message string) error {
+	return r.client.Publish(ctx, channel, message).Err()
+}
+
+func (r *RedisDataLimiter) Subscribe(ctx context.Context, channel string) (<-chan string, error) {
+	pubsub := r.client.Subscribe(ctx, channel)
+
+	// Aguarda a confirmação da inscrição antes de devolver o canal.
+	if _, err := pubsub.Receive(ctx); err != nil {
+		pubsub.Close()
+		return nil, err
+	}
+
+	messages := make(chan string)
+
+	go func() {
+		defer close(messages)
+		defer pubsub.Close()
+
+		ch := pubsub.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case msg, ok := <-ch:
+				if !ok {
+					return
+				}
+				select {
+				case messages <- msg.Payload:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+
+	return messages, nil
+}
+
+// ScanPrefix walks the keys with SCAN, so it doesn't block Redis like KEYS.
+// On Cluster every master is scanned.
+func (r *RedisDataLimiter) ScanPrefix(ctx context.Context, prefix string) ([]string, error) {
+	pattern := globEscaper.Replace(prefix) + "*"
+
+	if cluster, ok := r.client.(*redis.ClusterClient); ok {
+		var mu sync.Mutex
+		var keys []string
+		err := cluster.ForEachMaster(ctx, func(ctx context.Context, master *redis.Client) error {
+			masterKeys, err := scanKeys(ctx, master, pattern)
+			mu.Lock()
+			keys = append(keys, masterKeys...)
+			mu.Unlock()
+			return err
+		})
+		return keys, err
+	}
+
+	return scanKeys(ctx, r.client, pattern)
+}
+
+// globEscaper keeps the prefix from being read as a pattern by SCAN MATCH.
+var globEscaper = strings.NewReplacer(`\`, `\\`, "*", `\*`, "?", `\?`, "[", `\[`, "]", `\]`)
+
+func scanKeys(ctx context.Context, client redis.Cmdable, pattern string) ([]string, error) {
+	var keys []string
+	iter := client.Scan(ctx, 0, pattern, 1000).Iterator()
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+	}
+	return keys, iter.Err()
+}
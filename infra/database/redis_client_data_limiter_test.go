@@ -33,14 +33,48 @@ func setup() (*RedisDataLimiter, func()) {
 
 func TestNewRedisClient(t *testing.T) {
 	cfg := &config.Config{RedisURL: "localhost:6379"}
-	client := NewRedisClient(cfg)
+	universalClient, err := NewRedisClient(cfg)
 
-	assert.NotNil(t, client)
+	assert.NoError(t, err)
+	assert.NotNil(t, universalClient)
+	client, ok := universalClient.(*redis.Client)
+	assert.True(t, ok)
 	assert.Equal(t, cfg.RedisURL, client.Options().Addr)
 	assert.Equal(t, "", client.Options().Password)
 	assert.Equal(t, 0, client.Options().DB)
 }
 
+func TestNewRedisClient_Cluster(t *testing.T) {
+	cfg := &config.Config{RedisMode: RedisModeCluster, RedisAddrs: []string{"node1:6379", "node2:6379"}}
+	universalClient, err := NewRedisClient(cfg)
+
+	assert.NoError(t, err)
+	client, ok := universalClient.(*redis.ClusterClient)
+	assert.True(t, ok)
+	assert.Equal(t, cfg.RedisAddrs, client.Options().Addrs)
+}
+
+func TestNewRedisClient_Sentinel(t *testing.T) {
+	cfg := &config.Config{RedisMode: RedisModeSentinel, RedisAddrs: []string{"sentinel:26379"}, RedisMasterName: "mymaster"}
+	universalClient, err := NewRedisClient(cfg)
+
+	assert.NoError(t, err)
+	_, ok := universalClient.(*redis.Client)
+	assert.True(t, ok)
+}
+
+func TestNewRedisClient_SentinelWithoutMasterName(t *testing.T) {
+	_, err := NewRedisClient(&config.Config{RedisMode: RedisModeSentinel, RedisAddrs: []string{"sentinel:26379"}})
+
+	assert.Error(t, err)
+}
+
+func TestNewRedisClient_InvalidMode(t *testing.T) {
+	_, err := NewRedisClient(&config.Config{RedisMode: "ring", RedisURL: "localhost:6379"})
+
+	assert.Error(t, err)
+}
+
 func TestZAdd(t *testing.T) {
 	limiter, teardown := setup()
 	defer teardown()
@@ -130,3 +164,84 @@ func TestZRemRangeByScore(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, int64(1), removed)
 }
+
+func TestDel(t *testing.T) {
+	limiter, teardown := setup()
+	defer teardown()
+
+	ctx := context.Background()
+
+	err := limiter.Set(ctx, "key1", "value1", time.Minute)
+	assert.NoError(t, err)
+
+	removed, err := limiter.Del(ctx, "key1", "missing")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), removed)
+}
+
+func TestTTL(t *testing.T) {
+	limiter, teardown := setup()
+	defer teardown()
+
+	ctx := context.Background()
+
+	err := limiter.SetEX(ctx, "key1", "value1", time.Minute)
+	assert.NoError(t, err)
+
+	ttl, err := limiter.TTL(ctx, "key1")
+	assert.NoError(t, err)
+	assert.Equal(t, time.Minute, ttl)
+}
+
+func TestPublishSubscribe(t *testing.T) {
+	limiter, teardown := setup()
+	defer teardown()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	messages, err := limiter.Subscribe(ctx, "channel1")
+	assert.NoError(t, err)
+
+	err = limiter.Publish(ctx, "channel1", "message1")
+	assert.NoError(t, err)
+
+	select {
+	case msg := <-messages:
+		assert.Equal(t, "message1", msg)
+	case <-time.After(time.Second):
+		t.Fatal("Mensagem não recebida")
+	}
+
+	cancel()
+	for range messages {
+	}
+}
+
+func TestZRem(t *testing.T) {
+	limiter, teardown := setup()
+	defer teardown()
+
+	ctx := context.Background()
+
+	_, err := limiter.ZAdd(ctx, "key1", &redis.Z{Score: 1, Member: "member1"})
+	assert.NoError(t, err)
+
+	removed, err := limiter.ZRem(ctx, "key1", "member1")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), removed)
+}
+
+func TestZRangeWithScores(t *testing.T) {
+	limiter, teardown := setup()
+	defer teardown()
+
+	ctx := context.Background()
+
+	_, err := limiter.ZAdd(ctx, "key1", &redis.Z{Score: 2, Member: "member2"}, &redis.Z{Score: 1, Member: "member1"})
+	assert.NoError(t, err)
+
+	members, err := limiter.ZRangeWithScores(ctx, "key1", 0, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, []redis.Z{{Score: 1, Member: "member1"}}, members)
+}
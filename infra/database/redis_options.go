@@ -0,0 +1,164 @@
+package database
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/jpodlasnisky/ratelimiter/config"
+)
+
+const (
+	RedisModeSingle   = "single"
+	RedisModeSentinel = "sentinel"
+	RedisModeCluster  = "cluster"
+)
+
+// NewRedisOptions builds the client options from the configuration. REDIS_URL
+// accepts redis:// and rediss:// URLs or a plain host:port, and every explicit
+// setting in the configuration overrides what came from the URL.
+func NewRedisOptions(cfg *config.Config) (*redis.Options, error) {
+	opts := &redis.Options{Addr: cfg.RedisURL}
+
+	if strings.Contains(cfg.RedisURL, "://") {
+		parsed, err := redis.ParseURL(cfg.RedisURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid REDIS_URL: %w", err)
+		}
+		opts = parsed
+	}
+
+	if cfg.RedisUsername != "" {
+		opts.Username = cfg.RedisUsername
+	}
+	if cfg.RedisPassword != "" {
+		opts.Password = cfg.RedisPassword
+	}
+	if cfg.RedisDB != 0 {
+		opts.DB = cfg.RedisDB
+	}
+
+	if cfg.RedisPoolSize > 0 {
+		opts.PoolSize = cfg.RedisPoolSize
+	}
+	if cfg.RedisMinIdleConns > 0 {
+		opts.MinIdleConns = cfg.RedisMinIdleConns
+	}
+	if cfg.RedisMaxRetries != 0 {
+		opts.MaxRetries = cfg.RedisMaxRetries
+	}
+	if cfg.RedisDialTimeoutMs > 0 {
+		opts.DialTimeout = time.Duration(cfg.RedisDialTimeoutMs) * time.Millisecond
+	}
+	if cfg.RedisReadTimeoutMs > 0 {
+		opts.ReadTimeout = time.Duration(cfg.RedisReadTimeoutMs) * time.Millisecond
+	}
+	if cfg.RedisWriteTimeoutMs > 0 {
+		opts.WriteTimeout = time.Duration(cfg.RedisWriteTimeoutMs) * time.Millisecond
+	}
+	if cfg.RedisPoolTimeoutMs > 0 {
+		opts.PoolTimeout = time.Duration(cfg.RedisPoolTimeoutMs) * time.Millisecond
+	}
+
+	tlsConfig, err := newRedisTLSConfig(cfg, opts.TLSConfig)
+	if err != nil {
+		return nil, err
+	}
+	opts.TLSConfig = tlsConfig
+
+	return opts, nil
+}
+
+func newRedisTLSConfig(cfg *config.Config, tlsConfig *tls.Config) (*tls.Config, error) {
+	if !cfg.RedisTLSEnabled && tlsConfig == nil {
+		return nil, nil
+	}
+	if tlsConfig == nil {
+		tlsConfig = &tls.Config{}
+	}
+	tlsConfig.MinVersion = tls.VersionTLS12
+
+	if cfg.RedisTLSServerName != "" {
+		tlsConfig.ServerName = cfg.RedisTLSServerName
+	}
+
+	if cfg.RedisTLSCAFile != "" {
+		caCert, err := os.ReadFile(cfg.RedisTLSCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading REDIS_TLS_CA_FILE: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caCert) {
+			return nil, errors.New("REDIS_TLS_CA_FILE has no valid PEM certificate")
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if cfg.RedisTLSCertFile != "" || cfg.RedisTLSKeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.RedisTLSCertFile, cfg.RedisTLSKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("loading Redis client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+// NewRedisUniversalOptions extends NewRedisOptions with the seed addresses of
+// REDIS_ADDRS and the Sentinel settings. Without REDIS_ADDRS the address of
+// REDIS_URL is used as the only seed.
+func NewRedisUniversalOptions(cfg *config.Config) (*redis.UniversalOptions, error) {
+	opts, err := NewRedisOptions(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	addrs := cfg.RedisAddrs
+	if len(addrs) == 0 {
+		addrs = []string{opts.Addr}
+	}
+
+	return &redis.UniversalOptions{
+		Addrs:            addrs,
+		DB:               opts.DB,
+		Username:         opts.Username,
+		Password:         opts.Password,
+		SentinelPassword: cfg.RedisSentinelPassword,
+		MasterName:       cfg.RedisMasterName,
+		MaxRetries:       opts.MaxRetries,
+		DialTimeout:      opts.DialTimeout,
+		ReadTimeout:      opts.ReadTimeout,
+		WriteTimeout:     opts.WriteTimeout,
+		PoolSize:         opts.PoolSize,
+		MinIdleConns:     opts.MinIdleConns,
+		PoolTimeout:      opts.PoolTimeout,
+		TLSConfig:        opts.TLSConfig,
+	}, nil
+}
+
+// CheckConnection pings Redis so a wrong address or credential fails at
+// startup instead of on the first request.
+func CheckConnection(ctx context.Context, client redis.UniversalClient) error {
+	if err := client.Ping(ctx).Err(); err != nil {
+		return fmt.Errorf("could not connect to Redis at %s: %w", redisAddrs(client), err)
+	}
+	return nil
+}
+
+func redisAddrs(client redis.UniversalClient) string {
+	switch c := client.(type) {
+	case *redis.ClusterClient:
+		return strings.Join(c.Options().Addrs, ",")
+	case *redis.Client:
+		return c.Options().Addr
+	default:
+		return "unknown address"
+	}
+}
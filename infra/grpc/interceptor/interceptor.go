@@ -0,0 +1,136 @@
+// Package interceptor limits gRPC servers with the same limiter as the HTTP
+// middleware.
+package interceptor
+
+import (
+	"context"
+	"errors"
+	"net"
+
+	limiter "github.com/jpodlasnisky/ratelimiter/ratelimiter"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// APIKeyMetadata is the metadata key read for the token, the gRPC form of the
+// API_KEY header.
+const APIKeyMetadata = "api_key"
+
+type options struct {
+	methodLimits map[string]int64
+}
+
+type Option func(*options)
+
+// WithMethodLimit gives fullMethod, like "/orders.v1.Orders/Create", its own
+// limit per window. Each caller is counted apart for that method.
+func WithMethodLimit(fullMethod string, limit int64) Option {
+	return func(o *options) {
+		o.methodLimits[fullMethod] = limit
+	}
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{methodLimits: map[string]int64{}}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// UnaryServerInterceptor checks the limit before each unary call.
+func UnaryServerInterceptor(rateLimiter *limiter.RateLimiter, opts ...Option) grpc.UnaryServerInterceptor {
+	o := newOptions(opts)
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if err := o.check(ctx, rateLimiter, info.FullMethod); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor checks the limit once when each stream is opened.
+func StreamServerInterceptor(rateLimiter *limiter.RateLimiter, opts ...Option) grpc.StreamServerInterceptor {
+	o := newOptions(opts)
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := o.check(ss.Context(), rateLimiter, info.FullMethod); err != nil {
+			return err
+		}
+		return handler(srv, ss)
+	}
+}
+
+func (o *options) check(ctx context.Context, rateLimiter *limiter.RateLimiter, fullMethod string) error {
+	key, limit, err := o.resolve(ctx, rateLimiter, fullMethod)
+	if err != nil {
+		return statusFor(err)
+	}
+
+	decision, err := rateLimiter.CheckN(ctx, key, limit, 1)
+	if err != nil {
+		return statusFor(err)
+	}
+	if decision.Allowed {
+		return nil
+	}
+
+	st := status.New(codes.ResourceExhausted, "you have reached the maximum number of requests or actions allowed within a certain time frame")
+	if detailed, err := st.WithDetails(&errdetails.RetryInfo{RetryDelay: durationpb.New(decision.RetryAfter)}); err == nil {
+		st = detailed
+	}
+	return st.Err()
+}
+
+// statusFor maps an error of the limiter to a gRPC status: a token that
+// can't be used now is Unauthenticated, as the HTTP middleware answers 401.
+func statusFor(err error) error {
+	if errors.Is(err, limiter.ErrTokenExpired) || errors.Is(err, limiter.ErrTokenNotYetValid) {
+		return status.Error(codes.Unauthenticated, err.Error())
+	}
+	return status.Error(codes.Internal, err.Error())
+}
+
+// resolve returns the key counted for the call and its limit. Callers with a
+// known token use its policy and the others are counted by peer address,
+// unless the method has a limit of its own.
+func (o *options) resolve(ctx context.Context, rateLimiter *limiter.RateLimiter, fullMethod string) (string, int64, error) {
+	key, isToken := identity(ctx, rateLimiter)
+
+	if limit, exists := o.methodLimits[fullMethod]; exists {
+		if !isToken {
+			return limiter.KindMethod.Key(key, fullMethod), limit, nil
+		}
+		methodKey, err := rateLimiter.TokenKey(limiter.KindMethod, key, fullMethod)
+		return methodKey, limit, err
+	}
+
+	if isToken {
+		limit, err := rateLimiter.PolicyLimit(ctx, key)
+		return key, limit, err
+	}
+	return key, rateLimiter.IPLimit(), nil
+}
+
+func identity(ctx context.Context, rateLimiter *limiter.RateLimiter) (string, bool) {
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get(APIKeyMetadata); len(values) > 0 && rateLimiter.TokenExists(values[0]) {
+			return values[0], true
+		}
+	}
+
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return "unknown", false
+	}
+
+	addr := p.Addr.String()
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host, false
+	}
+	return addr, false
+}
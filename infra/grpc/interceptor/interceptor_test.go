@@ -0,0 +1,144 @@
+package interceptor
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/jpodlasnisky/ratelimiter/infra/database"
+	"github.com/jpodlasnisky/ratelimiter/infra/database/contract_db"
+	limiter "github.com/jpodlasnisky/ratelimiter/ratelimiter"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+const checkMethod = "/grpc.health.v1.Health/Check"
+
+func setupHealthClient(t *testing.T, opts ...Option) healthpb.HealthClient {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+
+	rateLimiter := limiter.New(contract_db.NewDatastoreStore(database.NewRedisDataLimiter(client)),
+		limiter.WithPolicies(map[string]int64{"TOKEN_1": 3, "TOKEN_LATER": 3}),
+		limiter.WithIPLimit(1),
+		limiter.WithTokenSchedules(map[string]limiter.TokenSchedule{"TOKEN_LATER": {NotBefore: time.Now().Add(time.Hour)}}),
+		limiter.WithWindow(time.Minute),
+	)
+	if err := rateLimiter.RegisterPersonalizedTokens(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	listener := bufconn.Listen(1024 * 1024)
+	grpcServer := grpc.NewServer(
+		grpc.UnaryInterceptor(UnaryServerInterceptor(rateLimiter, opts...)),
+		grpc.StreamInterceptor(StreamServerInterceptor(rateLimiter, opts...)),
+	)
+	healthpb.RegisterHealthServer(grpcServer, health.NewServer())
+	go grpcServer.Serve(listener)
+	t.Cleanup(grpcServer.Stop)
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return healthpb.NewHealthClient(conn)
+}
+
+func assertExhausted(t *testing.T, err error, retryDelay time.Duration) {
+	st := status.Convert(err)
+	assert.Equal(t, codes.ResourceExhausted, st.Code())
+
+	details := st.Details()
+	if assert.Len(t, details, 1) {
+		retryInfo, ok := details[0].(*errdetails.RetryInfo)
+		assert.True(t, ok)
+		assert.Equal(t, retryDelay, retryInfo.GetRetryDelay().AsDuration())
+	}
+}
+
+func TestUnaryServerInterceptor_PeerAddress(t *testing.T) {
+	client := setupHealthClient(t)
+	ctx := context.Background()
+
+	_, err := client.Check(ctx, &healthpb.HealthCheckRequest{})
+	assert.NoError(t, err)
+
+	_, err = client.Check(ctx, &healthpb.HealthCheckRequest{})
+	assertExhausted(t, err, time.Minute)
+}
+
+func TestUnaryServerInterceptor_APIKey(t *testing.T) {
+	client := setupHealthClient(t)
+	ctx := metadata.AppendToOutgoingContext(context.Background(), APIKeyMetadata, "TOKEN_1")
+
+	for i := 0; i < 3; i++ {
+		_, err := client.Check(ctx, &healthpb.HealthCheckRequest{})
+		assert.NoError(t, err)
+	}
+
+	_, err := client.Check(ctx, &healthpb.HealthCheckRequest{})
+	assertExhausted(t, err, time.Minute)
+}
+
+func TestUnaryServerInterceptor_MethodLimit(t *testing.T) {
+	client := setupHealthClient(t, WithMethodLimit(checkMethod, 2))
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		_, err := client.Check(ctx, &healthpb.HealthCheckRequest{})
+		assert.NoError(t, err)
+	}
+
+	_, err := client.Check(ctx, &healthpb.HealthCheckRequest{})
+	assertExhausted(t, err, time.Minute)
+}
+
+func TestStreamServerInterceptor(t *testing.T) {
+	client := setupHealthClient(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	stream, err := client.Watch(ctx, &healthpb.HealthCheckRequest{})
+	assert.NoError(t, err)
+	_, err = stream.Recv()
+	assert.NoError(t, err)
+
+	stream, err = client.Watch(ctx, &healthpb.HealthCheckRequest{})
+	assert.NoError(t, err)
+	_, err = stream.Recv()
+	assertExhausted(t, err, time.Minute)
+}
+
+func TestUnaryServerInterceptor_TokenNotValidYet(t *testing.T) {
+	client := setupHealthClient(t)
+	ctx := metadata.AppendToOutgoingContext(context.Background(), APIKeyMetadata, "TOKEN_LATER")
+
+	_, err := client.Check(ctx, &healthpb.HealthCheckRequest{})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+}
+
+func TestUnaryServerInterceptor_MethodLimitTokenNotValidYet(t *testing.T) {
+	client := setupHealthClient(t, WithMethodLimit(checkMethod, 2))
+	ctx := metadata.AppendToOutgoingContext(context.Background(), APIKeyMetadata, "TOKEN_LATER")
+
+	_, err := client.Check(ctx, &healthpb.HealthCheckRequest{})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err), "a method limit must not skip the schedule of the token")
+}
@@ -0,0 +1,224 @@
+// Package rls implements Envoy's RateLimitService, so the edge proxy can ask
+// the limiter for a decision over gRPC.
+package rls
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	ratelimitv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/common/ratelimit/v3"
+	rlsv3 "github.com/envoyproxy/go-control-plane/envoy/service/ratelimit/v3"
+	typev3 "github.com/envoyproxy/go-control-plane/envoy/type/v3"
+	limiter "github.com/jpodlasnisky/ratelimiter/ratelimiter"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+const (
+	// EntryRemoteAddress is the descriptor key of Envoy's remote_address action.
+	// Its value is limited with the IP limit, sharing the counters of the middleware.
+	EntryRemoteAddress = "remote_address"
+
+	// EntryAPIKey is the descriptor key expected for the API_KEY header. Its
+	// value is limited with the policy of the token.
+	EntryAPIKey = "api_key"
+)
+
+type Service struct {
+	rlsv3.UnimplementedRateLimitServiceServer
+	rateLimiter *limiter.RateLimiter
+}
+
+// Register adds the service to a gRPC server.
+func Register(server *grpc.Server, rateLimiter *limiter.RateLimiter) {
+	rlsv3.RegisterRateLimitServiceServer(server, NewService(rateLimiter))
+}
+
+func NewService(rateLimiter *limiter.RateLimiter) *Service {
+	return &Service{rateLimiter: rateLimiter}
+}
+
+// ShouldRateLimit checks every descriptor of the request. The request is over
+// the limit when any descriptor is. Descriptors the limiter doesn't know are
+// reported as OK without a limit, as Envoy's reference service does.
+func (s *Service) ShouldRateLimit(ctx context.Context, req *rlsv3.RateLimitRequest) (*rlsv3.RateLimitResponse, error) {
+	if len(req.GetDescriptors()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "rate limit descriptor list must not be empty")
+	}
+
+	hits := int(req.GetHitsAddend())
+	if hits == 0 {
+		hits = 1
+	}
+
+	resp := &rlsv3.RateLimitResponse{OverallCode: rlsv3.RateLimitResponse_OK}
+	for _, descriptor := range req.GetDescriptors() {
+		descriptorStatus, err := s.check(ctx, req.GetDomain(), descriptor, hits)
+		if err != nil {
+			return nil, err
+		}
+		if descriptorStatus.Code == rlsv3.RateLimitResponse_OVER_LIMIT {
+			resp.OverallCode = rlsv3.RateLimitResponse_OVER_LIMIT
+		}
+		resp.Statuses = append(resp.Statuses, descriptorStatus)
+	}
+	return resp, nil
+}
+
+func (s *Service) check(ctx context.Context, domain string, descriptor *ratelimitv3.RateLimitDescriptor, hits int) (*rlsv3.RateLimitResponse_DescriptorStatus, error) {
+	rule, err := s.resolve(ctx, domain, descriptor)
+	if err != nil {
+		return nil, err
+	}
+	if rule.key == "" {
+		return &rlsv3.RateLimitResponse_DescriptorStatus{Code: rlsv3.RateLimitResponse_OK}, nil
+	}
+
+	decision, err := s.rateLimiter.CheckNInWindow(ctx, rule.key, rule.limit, hits, rule.window)
+	if err != nil {
+		return nil, statusFor(err)
+	}
+
+	descriptorStatus := &rlsv3.RateLimitResponse_DescriptorStatus{
+		Code: rlsv3.RateLimitResponse_OK,
+		CurrentLimit: &rlsv3.RateLimitResponse_RateLimit{
+			RequestsPerUnit: uint32(decision.Limit),
+			Unit:            rule.unit,
+		},
+		LimitRemaining: uint32(decision.Remaining),
+	}
+	if !decision.Allowed {
+		descriptorStatus.Code = rlsv3.RateLimitResponse_OVER_LIMIT
+		descriptorStatus.DurationUntilReset = durationpb.New(decision.RetryAfter)
+	}
+	return descriptorStatus, nil
+}
+
+// rule is how a descriptor is counted: its key, the limit per window and the
+// window, with the unit Envoy is told the window is in. An empty key means
+// the descriptor is not limited.
+type rule struct {
+	key    string
+	limit  int64
+	window time.Duration
+	unit   rlsv3.RateLimitResponse_RateLimit_Unit
+}
+
+// resolve maps the descriptor to the rule of the limiter that applies to it.
+// A limit override is counted in its own unit instead of the limiter window.
+func (s *Service) resolve(ctx context.Context, domain string, descriptor *ratelimitv3.RateLimitDescriptor) (rule, error) {
+	entries := descriptor.GetEntries()
+	window := s.rateLimiter.Window()
+
+	if override := descriptor.GetLimit(); override != nil {
+		key, err := s.overrideKey(domain, entries)
+		if err != nil {
+			return rule{}, statusFor(err)
+		}
+		r := rule{key: key, limit: int64(override.GetRequestsPerUnit()), window: window, unit: unitFor(window)}
+		if overrideWindow, ok := windowFor(override.GetUnit()); ok {
+			r.window = overrideWindow
+			r.unit = rlsv3.RateLimitResponse_RateLimit_Unit(override.GetUnit())
+		}
+		return r, nil
+	}
+
+	if len(entries) != 1 {
+		return rule{}, nil
+	}
+
+	switch entries[0].GetKey() {
+	case EntryRemoteAddress:
+		return rule{key: entries[0].GetValue(), limit: s.rateLimiter.IPLimit(), window: window, unit: unitFor(window)}, nil
+
+	case EntryAPIKey:
+		token := entries[0].GetValue()
+		if !s.rateLimiter.TokenExists(token) {
+			return rule{}, nil
+		}
+		limit, err := s.rateLimiter.PolicyLimit(ctx, token)
+		if err != nil {
+			return rule{}, statusFor(err)
+		}
+		return rule{key: token, limit: limit, window: window, unit: unitFor(window)}, nil
+	}
+
+	return rule{}, nil
+}
+
+// statusFor maps an error of the limiter to a gRPC status: a token that
+// can't be used now is Unauthenticated and anything else Unavailable.
+func statusFor(err error) error {
+	if errors.Is(err, limiter.ErrTokenExpired) || errors.Is(err, limiter.ErrTokenNotYetValid) {
+		return status.Error(codes.Unauthenticated, err.Error())
+	}
+	return status.Error(codes.Unavailable, err.Error())
+}
+
+// compositeKey names the counter of a descriptor after its domain and entries,
+// so the same entries in different domains don't share a counter.
+func compositeKey(domain string, entries []*ratelimitv3.RateLimitDescriptor_Entry) string {
+	parts := []string{domain}
+	for _, entry := range entries {
+		parts = append(parts, entry.GetKey(), entry.GetValue())
+	}
+	return limiter.KindRLS.Key(parts...)
+}
+
+// overrideKey returns the key of a descriptor with a limit override. When it
+// carries the api_key entry of a known token, the key is derived from the
+// token, so its schedule and rotation apply as to the limit of the token.
+func (s *Service) overrideKey(domain string, entries []*ratelimitv3.RateLimitDescriptor_Entry) (string, error) {
+	for i, entry := range entries {
+		if entry.GetKey() != EntryAPIKey || !s.rateLimiter.TokenExists(entry.GetValue()) {
+			continue
+		}
+
+		parts := []string{domain}
+		for j, other := range entries {
+			if j != i {
+				parts = append(parts, other.GetKey(), other.GetValue())
+			}
+		}
+		return s.rateLimiter.TokenKey(limiter.KindRLS, entry.GetValue(), parts...)
+	}
+	return compositeKey(domain, entries), nil
+}
+
+// windowFor returns the window of an override unit. An override without a
+// unit is counted in the window of the limiter.
+func windowFor(unit typev3.RateLimitUnit) (time.Duration, bool) {
+	switch unit {
+	case typev3.RateLimitUnit_SECOND:
+		return time.Second, true
+	case typev3.RateLimitUnit_MINUTE:
+		return time.Minute, true
+	case typev3.RateLimitUnit_HOUR:
+		return time.Hour, true
+	case typev3.RateLimitUnit_DAY:
+		return 24 * time.Hour, true
+	case typev3.RateLimitUnit_MONTH:
+		return 30 * 24 * time.Hour, true
+	case typev3.RateLimitUnit_YEAR:
+		return 365 * 24 * time.Hour, true
+	}
+	return 0, false
+}
+
+// unitFor reports the window of the limiter in the units Envoy understands.
+func unitFor(window time.Duration) rlsv3.RateLimitResponse_RateLimit_Unit {
+	switch window {
+	case time.Second:
+		return rlsv3.RateLimitResponse_RateLimit_SECOND
+	case time.Minute:
+		return rlsv3.RateLimitResponse_RateLimit_MINUTE
+	case time.Hour:
+		return rlsv3.RateLimitResponse_RateLimit_HOUR
+	case 24 * time.Hour:
+		return rlsv3.RateLimitResponse_RateLimit_DAY
+	}
+	return rlsv3.RateLimitResponse_RateLimit_UNKNOWN
+}
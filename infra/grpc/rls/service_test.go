@@ -0,0 +1,178 @@
+package rls
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	ratelimitv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/common/ratelimit/v3"
+	rlsv3 "github.com/envoyproxy/go-control-plane/envoy/service/ratelimit/v3"
+	typev3 "github.com/envoyproxy/go-control-plane/envoy/type/v3"
+	"github.com/go-redis/redis/v8"
+	"github.com/jpodlasnisky/ratelimiter/infra/database"
+	"github.com/jpodlasnisky/ratelimiter/infra/database/contract_db"
+	limiter "github.com/jpodlasnisky/ratelimiter/ratelimiter"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func setupClient(t *testing.T) rlsv3.RateLimitServiceClient {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+
+	rateLimiter := limiter.New(contract_db.NewDatastoreStore(database.NewRedisDataLimiter(client)),
+		limiter.WithPolicies(map[string]int64{"TOKEN_1": 3, "TOKEN_LATER": 3}),
+		limiter.WithIPLimit(1),
+		limiter.WithTokenSchedules(map[string]limiter.TokenSchedule{"TOKEN_LATER": {NotBefore: time.Now().Add(time.Hour)}}),
+		limiter.WithWindow(time.Second),
+	)
+	if err := rateLimiter.RegisterPersonalizedTokens(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	listener := bufconn.Listen(1024 * 1024)
+	grpcServer := grpc.NewServer()
+	Register(grpcServer, rateLimiter)
+	go grpcServer.Serve(listener)
+	t.Cleanup(grpcServer.Stop)
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return rlsv3.NewRateLimitServiceClient(conn)
+}
+
+func descriptor(entries ...string) *ratelimitv3.RateLimitDescriptor {
+	d := &ratelimitv3.RateLimitDescriptor{}
+	for i := 0; i+1 < len(entries); i += 2 {
+		d.Entries = append(d.Entries, &ratelimitv3.RateLimitDescriptor_Entry{Key: entries[i], Value: entries[i+1]})
+	}
+	return d
+}
+
+func TestShouldRateLimit_RemoteAddress(t *testing.T) {
+	client := setupClient(t)
+	req := &rlsv3.RateLimitRequest{
+		Domain:      "edge",
+		Descriptors: []*ratelimitv3.RateLimitDescriptor{descriptor(EntryRemoteAddress, "10.0.0.1")},
+	}
+
+	resp, err := client.ShouldRateLimit(context.Background(), req)
+	assert.NoError(t, err)
+	assert.Equal(t, rlsv3.RateLimitResponse_OK, resp.OverallCode)
+	assert.Equal(t, uint32(1), resp.Statuses[0].CurrentLimit.RequestsPerUnit)
+	assert.Equal(t, rlsv3.RateLimitResponse_RateLimit_SECOND, resp.Statuses[0].CurrentLimit.Unit)
+
+	resp, err = client.ShouldRateLimit(context.Background(), req)
+	assert.NoError(t, err)
+	assert.Equal(t, rlsv3.RateLimitResponse_OVER_LIMIT, resp.OverallCode)
+	assert.Equal(t, time.Minute, resp.Statuses[0].DurationUntilReset.AsDuration())
+}
+
+func TestShouldRateLimit_Descriptors(t *testing.T) {
+	client := setupClient(t)
+
+	override := descriptor("path", "/orders")
+	override.Limit = &ratelimitv3.RateLimitDescriptor_RateLimitOverride{RequestsPerUnit: 5}
+
+	resp, err := client.ShouldRateLimit(context.Background(), &rlsv3.RateLimitRequest{
+		Domain:     "edge",
+		HitsAddend: 2,
+		Descriptors: []*ratelimitv3.RateLimitDescriptor{
+			descriptor(EntryAPIKey, "TOKEN_1"),
+			override,
+			descriptor("unknown", "value"),
+			descriptor(EntryAPIKey, "UNKNOWN"),
+		},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, rlsv3.RateLimitResponse_OK, resp.OverallCode)
+	assert.Len(t, resp.Statuses, 4)
+
+	assert.Equal(t, uint32(3), resp.Statuses[0].CurrentLimit.RequestsPerUnit)
+	assert.Equal(t, uint32(1), resp.Statuses[0].LimitRemaining)
+	assert.Equal(t, uint32(5), resp.Statuses[1].CurrentLimit.RequestsPerUnit)
+	assert.Equal(t, uint32(3), resp.Statuses[1].LimitRemaining)
+	assert.Nil(t, resp.Statuses[2].CurrentLimit)
+	assert.Nil(t, resp.Statuses[3].CurrentLimit)
+}
+
+func TestShouldRateLimit_EmptyRequest(t *testing.T) {
+	client := setupClient(t)
+
+	_, err := client.ShouldRateLimit(context.Background(), &rlsv3.RateLimitRequest{Domain: "edge"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestShouldRateLimit_TokenNotValidYet(t *testing.T) {
+	client := setupClient(t)
+
+	_, err := client.ShouldRateLimit(context.Background(), &rlsv3.RateLimitRequest{
+		Domain:      "edge",
+		Descriptors: []*ratelimitv3.RateLimitDescriptor{descriptor(EntryAPIKey, "TOKEN_LATER")},
+	})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+}
+
+func TestShouldRateLimit_OverrideTokenNotValidYet(t *testing.T) {
+	client := setupClient(t)
+
+	override := descriptor(EntryAPIKey, "TOKEN_LATER", "path", "/orders")
+	override.Limit = &ratelimitv3.RateLimitDescriptor_RateLimitOverride{RequestsPerUnit: 5}
+
+	_, err := client.ShouldRateLimit(context.Background(), &rlsv3.RateLimitRequest{
+		Domain:      "edge",
+		Descriptors: []*ratelimitv3.RateLimitDescriptor{override},
+	})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err), "an override must not skip the schedule of the token")
+}
+
+func TestShouldRateLimit_OverrideUnit(t *testing.T) {
+	client := setupClient(t)
+
+	override := descriptor("path", "/reports")
+	override.Limit = &ratelimitv3.RateLimitDescriptor_RateLimitOverride{RequestsPerUnit: 2, Unit: typev3.RateLimitUnit_HOUR}
+	req := &rlsv3.RateLimitRequest{Domain: "edge", Descriptors: []*ratelimitv3.RateLimitDescriptor{override}}
+
+	for i := 0; i < 2; i++ {
+		resp, err := client.ShouldRateLimit(context.Background(), req)
+		assert.NoError(t, err)
+		assert.Equal(t, rlsv3.RateLimitResponse_OK, resp.OverallCode)
+		assert.Equal(t, rlsv3.RateLimitResponse_RateLimit_HOUR, resp.Statuses[0].CurrentLimit.Unit)
+	}
+
+	// A janela do limitador é de um segundo; o override conta por hora
+	time.Sleep(1100 * time.Millisecond)
+
+	resp, err := client.ShouldRateLimit(context.Background(), req)
+	assert.NoError(t, err)
+	assert.Equal(t, rlsv3.RateLimitResponse_OVER_LIMIT, resp.OverallCode)
+}
+
+func TestCompositeKey_Escaped(t *testing.T) {
+	entry := func(key, value string) *ratelimitv3.RateLimitDescriptor_Entry {
+		return &ratelimitv3.RateLimitDescriptor_Entry{Key: key, Value: value}
+	}
+
+	forged := compositeKey("edge", []*ratelimitv3.RateLimitDescriptor_Entry{entry("k1", "v|k2=v")})
+	real := compositeKey("edge", []*ratelimitv3.RateLimitDescriptor_Entry{entry("k1", "v"), entry("k2", "v")})
+	assert.NotEqual(t, real, forged, "a value must not forge another entry")
+
+	key := compositeKey("edge", []*ratelimitv3.RateLimitDescriptor_Entry{entry("path", "/{tag}")})
+	assert.NotContains(t, key, "{")
+	assert.NotContains(t, key, "}")
+}
@@ -0,0 +1,192 @@
+// Package listener limits raw TCP servers, where RateLimitMiddleware can't
+// help, at the moment connections are accepted.
+package listener
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	limiter "github.com/jpodlasnisky/ratelimiter/ratelimiter"
+)
+
+const (
+	defaultConnLease = time.Hour
+)
+
+// Listener applies the IP limit and the block list to Accept. Connections
+// over the limit, or over the cap of concurrent connections of their IP, are
+// closed right away and never returned to the server. Each connection is
+// admitted in its own goroutine, so a slow store doesn't hold up the others.
+type Listener struct {
+	net.Listener
+	rateLimiter *limiter.RateLimiter
+	maxConns    int64
+	connLease   time.Duration
+
+	startOnce sync.Once
+	closeOnce sync.Once
+	admitted  chan net.Conn
+	errs      chan error
+	done      chan struct{}
+}
+
+type Option func(*Listener)
+
+// WithMaxConnsPerIP caps how many connections an IP can keep open at once,
+// across every instance. Zero, the default, means no cap.
+func WithMaxConnsPerIP(maxConns int64) Option {
+	return func(l *Listener) {
+		l.maxConns = maxConns
+	}
+}
+
+// WithConnLease sets how long an open connection holds its slot when its
+// instance dies without closing it. It should be longer than the longest
+// connection expected.
+func WithConnLease(lease time.Duration) Option {
+	return func(l *Listener) {
+		l.connLease = lease
+	}
+}
+
+func Wrap(inner net.Listener, rateLimiter *limiter.RateLimiter, opts ...Option) *Listener {
+	l := &Listener{
+		Listener:    inner,
+		rateLimiter: rateLimiter,
+		connLease:   defaultConnLease,
+		admitted:    make(chan net.Conn),
+		errs:        make(chan error),
+		done:        make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(l)
+	}
+	return l
+}
+
+// Accept waits for the next connection allowed by the limiter.
+func (l *Listener) Accept() (net.Conn, error) {
+	l.startOnce.Do(func() { go l.acceptLoop() })
+
+	select {
+	case conn := <-l.admitted:
+		return conn, nil
+	case err := <-l.errs:
+		return nil, err
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close stops the accept loop and closes the wrapped listener. Connections
+// still being admitted are closed.
+func (l *Listener) Close() error {
+	l.shutdown()
+	return l.Listener.Close()
+}
+
+func (l *Listener) shutdown() {
+	l.closeOnce.Do(func() { close(l.done) })
+}
+
+// acceptLoop accepts from the wrapped listener until it is closed. Other
+// errors, such as running out of file descriptors, are handed to Accept so
+// the server can back off as usual.
+func (l *Listener) acceptLoop() {
+	for {
+		conn, err := l.Listener.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			l.shutdown()
+			return
+		}
+		if err != nil {
+			select {
+			case l.errs <- err:
+			case <-l.done:
+				return
+			}
+			continue
+		}
+
+		go l.handOff(conn)
+	}
+}
+
+// handOff admits the connection and passes it to the next Accept.
+func (l *Listener) handOff(conn net.Conn) {
+	allowed := l.admit(conn)
+	if allowed == nil {
+		return
+	}
+
+	select {
+	case l.admitted <- allowed:
+	case <-l.done:
+		allowed.Close()
+	}
+}
+
+// admit returns the connection to hand to the server, or nil after closing it.
+func (l *Listener) admit(conn net.Conn) net.Conn {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	ip := remoteIP(conn)
+
+	isBlocked, err := l.rateLimiter.CheckRateLimitForKey(ctx, ip, false)
+	if err != nil {
+		log.Printf("Error checking rate limit for %s, closing the connection: %v", ip, err)
+		conn.Close()
+		return nil
+	}
+	if isBlocked {
+		conn.Close()
+		return nil
+	}
+
+	if l.maxConns <= 0 {
+		return conn
+	}
+
+	release, ok, err := l.rateLimiter.Acquire(ctx, limiter.KindConns.Key(ip), l.maxConns, l.connLease)
+	if err != nil {
+		log.Printf("Error acquiring connection slot for %s: %v", ip, err)
+		conn.Close()
+		return nil
+	}
+	if !ok {
+		log.Printf("IP %s reached the limit of %d concurrent connections", ip, l.maxConns)
+		conn.Close()
+		return nil
+	}
+
+	return &limitedConn{Conn: conn, release: release}
+}
+
+func remoteIP(conn net.Conn) string {
+	addr := conn.RemoteAddr().String()
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
+
+// limitedConn gives its slot back when it is closed.
+type limitedConn struct {
+	net.Conn
+	release func(context.Context) error
+}
+
+func (c *limitedConn) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := c.release(ctx); err != nil {
+		log.Printf("Error releasing connection slot: %v", err)
+	}
+	return c.Conn.Close()
+}
@@ -0,0 +1,147 @@
+package listener
+
+import (
+	"context"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/jpodlasnisky/ratelimiter/infra/database"
+	"github.com/jpodlasnisky/ratelimiter/infra/database/contract_db"
+	limiter "github.com/jpodlasnisky/ratelimiter/ratelimiter"
+	"github.com/stretchr/testify/assert"
+)
+
+func setupListener(t *testing.T, ipLimit int64, opts ...Option) (*Listener, chan net.Conn) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+
+	return setupListenerWithStore(t, contract_db.NewDatastoreStore(database.NewRedisDataLimiter(client)), ipLimit, opts...)
+}
+
+func setupListenerWithStore(t *testing.T, store contract_db.LimiterStore, ipLimit int64, opts ...Option) (*Listener, chan net.Conn) {
+	rateLimiter := limiter.New(store,
+		limiter.WithIPLimit(ipLimit),
+		limiter.WithWindow(time.Minute),
+	)
+
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := Wrap(inner, rateLimiter, opts...)
+	t.Cleanup(func() { l.Close() })
+
+	accepted := make(chan net.Conn, 10)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				close(accepted)
+				return
+			}
+			accepted <- conn
+		}
+	}()
+
+	return l, accepted
+}
+
+// slowStore holds the first count of the window until release is closed.
+type slowStore struct {
+	contract_db.LimiterStore
+	calls   atomic.Int64
+	release chan struct{}
+}
+
+func (s *slowStore) CountInWindow(ctx context.Context, key string, now time.Time) (int64, error) {
+	if s.calls.Add(1) == 1 {
+		<-s.release
+	}
+	return s.LimiterStore.CountInWindow(ctx, key, now)
+}
+
+func dial(t *testing.T, l net.Listener) net.Conn {
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func assertClosedByServer(t *testing.T, conn net.Conn) {
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, err := conn.Read(make([]byte, 1))
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func assertAccepted(t *testing.T, accepted chan net.Conn) net.Conn {
+	select {
+	case conn := <-accepted:
+		return conn
+	case <-time.After(2 * time.Second):
+		t.Fatal("connection not accepted")
+		return nil
+	}
+}
+
+func TestListener_ConnectionRate(t *testing.T) {
+	l, accepted := setupListener(t, 2)
+
+	for i := 0; i < 2; i++ {
+		dial(t, l)
+		assertAccepted(t, accepted)
+	}
+
+	assertClosedByServer(t, dial(t, l))
+	assert.Empty(t, accepted)
+}
+
+func TestListener_MaxConnsPerIP(t *testing.T) {
+	l, accepted := setupListener(t, 100, WithMaxConnsPerIP(1))
+
+	dial(t, l)
+	first := assertAccepted(t, accepted)
+
+	assertClosedByServer(t, dial(t, l))
+
+	assert.NoError(t, first.Close())
+
+	dial(t, l)
+	assertAccepted(t, accepted)
+}
+
+func TestListener_SlowAdmissionDoesNotBlockAccept(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	store := &slowStore{
+		LimiterStore: contract_db.NewDatastoreStore(database.NewRedisDataLimiter(client)),
+		release:      make(chan struct{}),
+	}
+	l, accepted := setupListenerWithStore(t, store, 100)
+
+	dial(t, l)
+	dial(t, l)
+	assertAccepted(t, accepted)
+
+	close(store.release)
+	assertAccepted(t, accepted)
+}
+
+func TestListener_CloseUnblocksAccept(t *testing.T) {
+	l, accepted := setupListener(t, 100)
+
+	assert.NoError(t, l.Close())
+
+	select {
+	case _, open := <-accepted:
+		assert.False(t, open)
+	case <-time.After(2 * time.Second):
+		t.Fatal("Accept did not return after Close")
+	}
+}
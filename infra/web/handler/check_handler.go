@@ -0,0 +1,200 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+
+	limiter "github.com/jpodlasnisky/ratelimiter/ratelimiter"
+)
+
+const (
+	KeyTypeIP    = "ip"
+	KeyTypeToken = "token"
+
+	// maxDescriptors limits how many checks a single call can carry.
+	maxDescriptors = 100
+
+	// maxCheckBodySize limits the body of a call, read before any check.
+	maxCheckBodySize = 1 << 20
+)
+
+// Descriptor is one check asked to the decision service. Keys of type ip use
+// the IP limit and keys of type token use the policy of the token, so they
+// share the counters of the middleware. Any other key type needs a rule,
+// which names the token whose policy applies to the key.
+type Descriptor struct {
+	KeyType string `json:"keyType"`
+	Key     string `json:"key"`
+	Cost    int    `json:"cost,omitempty"`
+	Rule    string `json:"rule,omitempty"`
+}
+
+type CheckRequest struct {
+	Descriptors []Descriptor `json:"descriptors"`
+}
+
+type CheckResult struct {
+	Allowed      bool   `json:"allowed"`
+	Limit        int64  `json:"limit"`
+	Remaining    int64  `json:"remaining"`
+	RetryAfterMs int64  `json:"retryAfterMs,omitempty"`
+	Error        string `json:"error,omitempty"`
+}
+
+// CheckResponse is allowed only when every descriptor of the call is.
+type CheckResponse struct {
+	Allowed bool          `json:"allowed"`
+	Results []CheckResult `json:"results"`
+}
+
+// CheckHandler serves POST /v1/check, so services written in other
+// languages can ask the limiter for a decision.
+func CheckHandler(rateLimiter *limiter.RateLimiter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req CheckRequest
+		r.Body = http.MaxBytesReader(w, r.Body, maxCheckBodySize)
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				http.Error(w, "Request Entity Too Large", http.StatusRequestEntityTooLarge)
+				return
+			}
+			http.Error(w, "Bad Request: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if len(req.Descriptors) == 0 || len(req.Descriptors) > maxDescriptors {
+			http.Error(w, fmt.Sprintf("Bad Request: expected between 1 and %d descriptors", maxDescriptors), http.StatusBadRequest)
+			return
+		}
+
+		// Todos os descritores são resolvidos antes de contar qualquer um, para
+		// que um token inválido no fim não consuma a cota dos anteriores
+		resolved := make([]resolvedDescriptor, len(req.Descriptors))
+		for i, descriptor := range req.Descriptors {
+			var err error
+			if resolved[i], err = resolve(r.Context(), rateLimiter, descriptor); err != nil {
+				http.Error(w, "Unauthorized: "+err.Error(), http.StatusUnauthorized)
+				return
+			}
+		}
+
+		resp := CheckResponse{Allowed: true, Results: make([]CheckResult, len(req.Descriptors))}
+		for i, descriptor := range resolved {
+			result, err := check(r.Context(), rateLimiter, descriptor)
+			if err != nil {
+				// Um token expirado é um erro de autenticação do chamador, como no middleware
+				http.Error(w, "Unauthorized: "+err.Error(), http.StatusUnauthorized)
+				return
+			}
+			resp.Results[i] = result
+			resp.Allowed = resp.Allowed && result.Allowed
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			http.Error(w, "Internal Server Error: "+err.Error(), http.StatusInternalServerError)
+		}
+	}
+}
+
+// resolvedDescriptor is a descriptor ready to be checked, or the reason it
+// can't be.
+type resolvedDescriptor struct {
+	key   string
+	limit int64
+	cost  int
+	err   error
+}
+
+// resolve validates one descriptor without counting it. Its error is only set
+// when the token of the descriptor can't be used now; any other failure is
+// kept to be reported in the result.
+func resolve(ctx context.Context, rateLimiter *limiter.RateLimiter, descriptor Descriptor) (resolvedDescriptor, error) {
+	key, limit, err := resolveDescriptor(ctx, rateLimiter, descriptor)
+	if isTokenScheduleError(err) {
+		return resolvedDescriptor{}, err
+	}
+	if err != nil {
+		return resolvedDescriptor{err: err}, nil
+	}
+
+	cost := descriptor.Cost
+	if cost == 0 {
+		cost = 1
+	}
+	return resolvedDescriptor{key: key, limit: limit, cost: cost}, nil
+}
+
+// check decides one resolved descriptor. Its error is only set when the token
+// of the descriptor can't be used now; any other failure is reported in the
+// result.
+func check(ctx context.Context, rateLimiter *limiter.RateLimiter, descriptor resolvedDescriptor) (CheckResult, error) {
+	if descriptor.err != nil {
+		return CheckResult{Error: descriptor.err.Error()}, nil
+	}
+
+	decision, err := rateLimiter.CheckN(ctx, descriptor.key, descriptor.limit, descriptor.cost)
+	if isTokenScheduleError(err) {
+		return CheckResult{}, err
+	}
+	if err != nil {
+		return CheckResult{Limit: descriptor.limit, Error: err.Error()}, nil
+	}
+
+	return CheckResult{
+		Allowed:      decision.Allowed,
+		Limit:        decision.Limit,
+		Remaining:    decision.Remaining,
+		RetryAfterMs: decision.RetryAfter.Milliseconds(),
+	}, nil
+}
+
+func isTokenScheduleError(err error) bool {
+	return errors.Is(err, limiter.ErrTokenExpired) || errors.Is(err, limiter.ErrTokenNotYetValid)
+}
+
+// resolveDescriptor returns the key counted for the descriptor and its limit.
+func resolveDescriptor(ctx context.Context, rateLimiter *limiter.RateLimiter, descriptor Descriptor) (string, int64, error) {
+	if descriptor.Key == "" {
+		return "", 0, errors.New("key is required")
+	}
+	if descriptor.Cost < 0 {
+		return "", 0, errors.New("cost must not be negative")
+	}
+
+	switch {
+	case descriptor.Rule != "":
+		limit, err := rateLimiter.PolicyLimit(ctx, descriptor.Rule)
+		if err != nil {
+			return "", 0, fmt.Errorf("rule %s: %w", descriptor.Rule, err)
+		}
+		return limiter.KindCheck.Key(descriptor.KeyType, descriptor.Key), limit, nil
+
+	case descriptor.KeyType == KeyTypeIP:
+		if net.ParseIP(descriptor.Key) == nil {
+			return "", 0, fmt.Errorf("invalid IP %q", descriptor.Key)
+		}
+		return descriptor.Key, rateLimiter.IPLimit(), nil
+
+	case descriptor.KeyType == KeyTypeToken:
+		if !rateLimiter.TokenExists(descriptor.Key) {
+			return "", 0, errors.New("token não encontrado")
+		}
+		limit, err := rateLimiter.PolicyLimit(ctx, descriptor.Key)
+		return descriptor.Key, limit, err
+
+	default:
+		return "", 0, fmt.Errorf("key type %q needs a rule", descriptor.KeyType)
+	}
+}
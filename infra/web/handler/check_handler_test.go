@@ -0,0 +1,180 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/jpodlasnisky/ratelimiter/infra/database"
+	"github.com/jpodlasnisky/ratelimiter/infra/database/contract_db"
+	limiter "github.com/jpodlasnisky/ratelimiter/ratelimiter"
+	"github.com/stretchr/testify/assert"
+)
+
+func setupCheckHandler(t *testing.T) http.Handler {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+
+	rateLimiter := limiter.New(contract_db.NewDatastoreStore(database.NewRedisDataLimiter(client)),
+		limiter.WithPolicies(map[string]int64{"TOKEN_1": 3, "TOKEN_LATER": 3}),
+		limiter.WithIPLimit(2),
+		limiter.WithTokenSchedules(map[string]limiter.TokenSchedule{"TOKEN_LATER": {NotBefore: time.Now().Add(time.Hour)}}),
+		limiter.WithWindow(time.Minute),
+	)
+	if err := rateLimiter.RegisterPersonalizedTokens(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	return CheckHandler(rateLimiter)
+}
+
+func postCheck(handler http.Handler, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/v1/check", strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func decodeCheck(t *testing.T, rec *httptest.ResponseRecorder) CheckResponse {
+	var resp CheckResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func TestCheckHandler_Batch(t *testing.T) {
+	handler := setupCheckHandler(t)
+
+	rec := postCheck(handler, `{"descriptors":[
+		{"keyType":"ip","key":"10.0.0.1"},
+		{"keyType":"token","key":"TOKEN_1","cost":2},
+		{"keyType":"user","key":"42","rule":"TOKEN_1"}
+	]}`)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	resp := decodeCheck(t, rec)
+	assert.True(t, resp.Allowed)
+	assert.Equal(t, []CheckResult{
+		{Allowed: true, Limit: 2, Remaining: 1},
+		{Allowed: true, Limit: 3, Remaining: 1},
+		{Allowed: true, Limit: 3, Remaining: 2},
+	}, resp.Results)
+}
+
+func TestCheckHandler_OverLimit(t *testing.T) {
+	handler := setupCheckHandler(t)
+	body := `{"descriptors":[{"keyType":"ip","key":"10.0.0.1","cost":2}]}`
+
+	assert.True(t, decodeCheck(t, postCheck(handler, body)).Allowed)
+
+	resp := decodeCheck(t, postCheck(handler, body))
+	assert.False(t, resp.Allowed)
+	assert.Equal(t, int64(time.Minute/time.Millisecond), resp.Results[0].RetryAfterMs)
+}
+
+func TestCheckHandler_InvalidDescriptors(t *testing.T) {
+	handler := setupCheckHandler(t)
+
+	rec := postCheck(handler, `{"descriptors":[
+		{"keyType":"token","key":"UNKNOWN"},
+		{"keyType":"user","key":"42"},
+		{"keyType":"ip","key":""}
+	]}`)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	resp := decodeCheck(t, rec)
+	assert.False(t, resp.Allowed)
+	for _, result := range resp.Results {
+		assert.False(t, result.Allowed)
+		assert.NotEmpty(t, result.Error)
+	}
+}
+
+func TestCheckHandler_RuleKeysAreNamespaced(t *testing.T) {
+	handler := setupCheckHandler(t)
+
+	for i := 0; i < 2; i++ {
+		rec := postCheck(handler, `{"descriptors":[{"keyType":"ip","key":"10.0.0.1"}]}`)
+		assert.True(t, decodeCheck(t, rec).Allowed)
+	}
+
+	// A mesma chave vinda de uma regra não pode gastar o limite do IP.
+	rec := postCheck(handler, `{"descriptors":[{"keyType":"10.0.0","key":"1","rule":"TOKEN_1"}]}`)
+	assert.True(t, decodeCheck(t, rec).Allowed)
+
+	rec = postCheck(handler, `{"descriptors":[{"keyType":"ip","key":"org:acme"}]}`)
+	resp := decodeCheck(t, rec)
+	assert.False(t, resp.Allowed)
+	assert.NotEmpty(t, resp.Results[0].Error, "ip descriptors must carry an IP")
+}
+
+func TestCheckHandler_BadRequest(t *testing.T) {
+	handler := setupCheckHandler(t)
+
+	assert.Equal(t, http.StatusBadRequest, postCheck(handler, `not json`).Code)
+	assert.Equal(t, http.StatusBadRequest, postCheck(handler, `{"descriptors":[]}`).Code)
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/check", nil)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+}
+
+func TestCheckHandler_TokenNotValidYet(t *testing.T) {
+	handler := setupCheckHandler(t)
+
+	rec := postCheck(handler, `{"descriptors":[{"keyType":"token","key":"TOKEN_LATER"}]}`)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.Contains(t, rec.Body.String(), limiter.ErrTokenNotYetValid.Error())
+}
+
+func TestCheckHandler_InvalidTokenConsumesNoQuota(t *testing.T) {
+	handler := setupCheckHandler(t)
+
+	rec := postCheck(handler, `{"descriptors":[
+		{"keyType":"ip","key":"10.0.0.1","cost":2},
+		{"keyType":"token","key":"TOKEN_LATER"}
+	]}`)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	rec = postCheck(handler, `{"descriptors":[{"keyType":"ip","key":"10.0.0.1","cost":2}]}`)
+	assert.True(t, decodeCheck(t, rec).Allowed, "the rejected call must not count the IP")
+}
+
+func TestCheckHandler_Concurrent(t *testing.T) {
+	handler := setupCheckHandler(t)
+
+	var wg sync.WaitGroup
+	var allowed atomic.Int64
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rec := postCheck(handler, `{"descriptors":[{"keyType":"token","key":"TOKEN_1"}]}`)
+			var resp CheckResponse
+			assert.NoError(t, json.NewDecoder(rec.Body).Decode(&resp))
+			if resp.Allowed {
+				allowed.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.LessOrEqual(t, allowed.Load(), int64(3))
+}
+
+func TestCheckHandler_BodyTooLarge(t *testing.T) {
+	handler := setupCheckHandler(t)
+
+	body := `{"descriptors":[{"keyType":"ip","key":"` + strings.Repeat("1", maxCheckBodySize) + `"}]}`
+	assert.Equal(t, http.StatusRequestEntityTooLarge, postCheck(handler, body).Code)
+}
@@ -0,0 +1,129 @@
+// Package jwtauth limits requests by the identity of a verified JWT, with the
+// limit taken from the plan of the token.
+package jwtauth
+
+import (
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/jpodlasnisky/ratelimiter/infra/web/middleware"
+	limiter "github.com/jpodlasnisky/ratelimiter/ratelimiter"
+)
+
+type Config struct {
+	// HMACSecret enables HS256 tokens.
+	HMACSecret []byte
+
+	// JWKSFile enables RS256 tokens, signed by one of the keys of the file.
+	JWKSFile string
+
+	// KeyClaim is the claim used as the rate limit key, such as "sub" or "tenant_id".
+	KeyClaim string
+
+	// PlanClaim is the claim that names the plan of the token, such as "plan".
+	PlanClaim string
+
+	// Plans maps each plan to its limit per window.
+	Plans map[string]int64
+
+	// DefaultLimit applies to tokens without a known plan. Zero means the IP limit.
+	DefaultLimit int64
+}
+
+type Verifier struct {
+	cfg     Config
+	rsaKeys map[string]*rsa.PublicKey
+	parser  *jwt.Parser
+}
+
+func NewVerifier(cfg Config) (*Verifier, error) {
+	if cfg.KeyClaim == "" {
+		return nil, errors.New("jwt key claim is required")
+	}
+
+	v := &Verifier{cfg: cfg}
+	var methods []string
+
+	if len(cfg.HMACSecret) > 0 {
+		methods = append(methods, jwt.SigningMethodHS256.Alg())
+	}
+
+	if cfg.JWKSFile != "" {
+		keys, err := loadJWKS(cfg.JWKSFile)
+		if err != nil {
+			return nil, err
+		}
+		v.rsaKeys = keys
+		methods = append(methods, jwt.SigningMethodRS256.Alg())
+	}
+
+	if len(methods) == 0 {
+		return nil, errors.New("jwt needs an HMAC secret or a JWKS file")
+	}
+
+	v.parser = jwt.NewParser(jwt.WithValidMethods(methods), jwt.WithExpirationRequired())
+	return v, nil
+}
+
+// Identity reads the bearer token of the request. Requests without a token
+// that looks like a JWT have no identity, so other extractors can run.
+func (v *Verifier) Identity(r *http.Request) (middleware.Identity, bool, error) {
+	scheme, raw, found := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") || strings.Count(raw, ".") != 2 {
+		return middleware.Identity{}, false, nil
+	}
+
+	claims := jwt.MapClaims{}
+	if _, err := v.parser.ParseWithClaims(strings.TrimSpace(raw), claims, v.key); err != nil {
+		return middleware.Identity{}, false, fmt.Errorf("invalid JWT: %w", err)
+	}
+
+	key, ok := claims[v.cfg.KeyClaim]
+	if !ok || fmt.Sprint(key) == "" {
+		return middleware.Identity{}, false, fmt.Errorf("JWT without the %s claim", v.cfg.KeyClaim)
+	}
+
+	identity := middleware.Identity{
+		Key:    limiter.KindJWT.Key(fmt.Sprint(key)),
+		Limit:  v.cfg.DefaultLimit,
+		Claims: map[string]string{},
+	}
+	for name, value := range claims {
+		switch value := value.(type) {
+		case string:
+			identity.Claims[name] = value
+		case float64:
+			identity.Claims[name] = strconv.FormatFloat(value, 'f', -1, 64)
+		case bool:
+			identity.Claims[name] = strconv.FormatBool(value)
+		}
+	}
+	if plan, ok := claims[v.cfg.PlanClaim].(string); ok {
+		if limit, exists := v.cfg.Plans[plan]; exists {
+			identity.Limit = limit
+		}
+	}
+	return identity, true, nil
+}
+
+func (v *Verifier) key(token *jwt.Token) (interface{}, error) {
+	if token.Method == jwt.SigningMethodHS256 {
+		return v.cfg.HMACSecret, nil
+	}
+
+	kid, _ := token.Header["kid"].(string)
+	if key, exists := v.rsaKeys[kid]; exists {
+		return key, nil
+	}
+	if kid == "" && len(v.rsaKeys) == 1 {
+		for _, key := range v.rsaKeys {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown key id %q", kid)
+}
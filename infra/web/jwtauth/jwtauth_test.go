@@ -0,0 +1,162 @@
+package jwtauth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/jpodlasnisky/ratelimiter/infra/web/middleware"
+	"github.com/stretchr/testify/assert"
+)
+
+var hmacSecret = []byte("test-secret")
+
+func writeJWKS(t *testing.T, kid string, key *rsa.PublicKey) string {
+	set := jwks{Keys: []jwk{{
+		Kty: "RSA",
+		Kid: kid,
+		N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}}}
+
+	data, err := json.Marshal(set)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func setupVerifier(t *testing.T) (*Verifier, *rsa.PrivateKey) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	verifier, err := NewVerifier(Config{
+		HMACSecret:   hmacSecret,
+		JWKSFile:     writeJWKS(t, "key-1", &privateKey.PublicKey),
+		KeyClaim:     "tenant_id",
+		PlanClaim:    "plan",
+		Plans:        map[string]int64{"gold": 100, "silver": 20},
+		DefaultLimit: 5,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return verifier, privateKey
+}
+
+func sign(t *testing.T, method jwt.SigningMethod, key interface{}, kid string, claims jwt.MapClaims) string {
+	token := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func identify(verifier *Verifier, authorization string) (middleware.Identity, bool, error) {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+	return verifier.Identity(req)
+}
+
+func TestIdentity_RS256(t *testing.T) {
+	verifier, privateKey := setupVerifier(t)
+	exp := time.Now().Add(time.Hour).Unix()
+	token := sign(t, jwt.SigningMethodRS256, privateKey, "key-1", jwt.MapClaims{
+		"tenant_id": "acme",
+		"plan":      "gold",
+		"exp":       exp,
+	})
+
+	identity, found, err := identify(verifier, "Bearer "+token)
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, "jwt:acme", identity.Key)
+	assert.Equal(t, int64(100), identity.Limit)
+	assert.Equal(t, "gold", identity.Claims["plan"], "the claims must be available to key templates")
+	assert.Equal(t, strconv.FormatInt(exp, 10), identity.Claims["exp"])
+}
+
+func TestIdentity_HS256WithoutPlan(t *testing.T) {
+	verifier, _ := setupVerifier(t)
+	token := sign(t, jwt.SigningMethodHS256, hmacSecret, "", jwt.MapClaims{
+		"tenant_id": "acme",
+		"plan":      "platinum",
+		"exp":       time.Now().Add(time.Hour).Unix(),
+	})
+
+	identity, found, err := identify(verifier, "Bearer "+token)
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, "jwt:acme", identity.Key)
+	assert.Equal(t, int64(5), identity.Limit, "an unknown plan must get the default limit")
+}
+
+func TestIdentity_Invalid(t *testing.T) {
+	verifier, privateKey := setupVerifier(t)
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	valid := jwt.MapClaims{"tenant_id": "acme", "exp": time.Now().Add(time.Hour).Unix()}
+
+	tests := map[string]string{
+		"expired":       sign(t, jwt.SigningMethodHS256, hmacSecret, "", jwt.MapClaims{"tenant_id": "acme", "exp": time.Now().Add(-time.Hour).Unix()}),
+		"without exp":   sign(t, jwt.SigningMethodHS256, hmacSecret, "", jwt.MapClaims{"tenant_id": "acme"}),
+		"wrong secret":  sign(t, jwt.SigningMethodHS256, []byte("other"), "", valid),
+		"wrong key":     sign(t, jwt.SigningMethodRS256, otherKey, "key-1", valid),
+		"unknown kid":   sign(t, jwt.SigningMethodRS256, privateKey, "key-2", valid),
+		"missing claim": sign(t, jwt.SigningMethodHS256, hmacSecret, "", jwt.MapClaims{"sub": "acme", "exp": time.Now().Add(time.Hour).Unix()}),
+		"alg not valid": sign(t, jwt.SigningMethodHS384, hmacSecret, "", valid),
+	}
+
+	for name, token := range tests {
+		_, _, err := identify(verifier, "Bearer "+token)
+		assert.Error(t, err, name)
+	}
+}
+
+func TestIdentity_NotAJWT(t *testing.T) {
+	verifier, _ := setupVerifier(t)
+
+	for _, authorization := range []string{"", "Bearer TOKEN_1", "Basic dXNlcjpwYXNz"} {
+		_, found, err := identify(verifier, authorization)
+		assert.NoError(t, err, authorization)
+		assert.False(t, found, authorization)
+	}
+}
+
+func TestNewVerifier_Config(t *testing.T) {
+	_, err := NewVerifier(Config{HMACSecret: hmacSecret})
+	assert.Error(t, err, "the key claim is required")
+
+	_, err = NewVerifier(Config{KeyClaim: "sub"})
+	assert.Error(t, err, "a secret or JWKS file is required")
+
+	_, err = NewVerifier(Config{KeyClaim: "sub", JWKSFile: filepath.Join(t.TempDir(), "missing.json")})
+	assert.Error(t, err)
+}
@@ -0,0 +1,61 @@
+package middleware
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+
+	limiter "github.com/jpodlasnisky/ratelimiter/ratelimiter"
+)
+
+// CertIdentitySource is the part of the client certificate used as the key.
+type CertIdentitySource string
+
+const (
+	CertSubjectCN   CertIdentitySource = "cn"
+	CertSANURI      CertIdentitySource = "san-uri"
+	CertFingerprint CertIdentitySource = "fingerprint"
+)
+
+// FromClientCert identifies a request by the verified client certificate of
+// its mTLS connection: its subject CN, its first SAN URI (a SPIFFE ID) or the
+// SHA-256 fingerprint. The limit of each identity comes from policies, and
+// defaultLimit applies to the others.
+func FromClientCert(source CertIdentitySource, policies map[string]int64, defaultLimit int64) (IdentityExtractor, error) {
+	switch source {
+	case CertSubjectCN, CertSANURI, CertFingerprint:
+	default:
+		return nil, fmt.Errorf("invalid client certificate identity %q", source)
+	}
+
+	return func(r *http.Request) (Identity, bool, error) {
+		if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+			return Identity{}, false, nil
+		}
+		cert := r.TLS.PeerCertificates[0]
+
+		var id string
+		switch source {
+		case CertSubjectCN:
+			id = cert.Subject.CommonName
+		case CertSANURI:
+			if len(cert.URIs) > 0 {
+				id = cert.URIs[0].String()
+			}
+		case CertFingerprint:
+			sum := sha256.Sum256(cert.Raw)
+			id = hex.EncodeToString(sum[:])
+		}
+
+		if id == "" {
+			return Identity{}, false, fmt.Errorf("client certificate without %s", source)
+		}
+
+		limit, exists := policies[id]
+		if !exists {
+			limit = defaultLimit
+		}
+		return Identity{Key: limiter.KindCert.Key(id), Limit: limit}, true, nil
+	}, nil
+}
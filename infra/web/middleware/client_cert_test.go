@@ -0,0 +1,76 @@
+package middleware
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func requestWithCert(cert *x509.Certificate) *http.Request {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	if cert != nil {
+		req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+	}
+	return req
+}
+
+func TestFromClientCert(t *testing.T) {
+	spiffeID, _ := url.Parse("spiffe://acme.internal/billing")
+	cert := &x509.Certificate{
+		Raw:     []byte("raw certificate"),
+		Subject: pkix.Name{CommonName: "billing"},
+		URIs:    []*url.URL{spiffeID},
+	}
+	sum := sha256.Sum256(cert.Raw)
+	fingerprint := hex.EncodeToString(sum[:])
+
+	policies := map[string]int64{"billing": 50, "spiffe://acme.internal/billing": 70, fingerprint: 90}
+
+	tests := []struct {
+		source   CertIdentitySource
+		expected Identity
+	}{
+		{CertSubjectCN, Identity{Key: "cert:billing", Limit: 50}},
+		{CertSANURI, Identity{Key: "cert:spiffe%3A%2F%2Facme.internal%2Fbilling", Limit: 70}},
+		{CertFingerprint, Identity{Key: "cert:" + fingerprint, Limit: 90}},
+	}
+
+	for _, tt := range tests {
+		extract, err := FromClientCert(tt.source, policies, 5)
+		assert.NoError(t, err)
+
+		identity, found, err := extract(requestWithCert(cert))
+		assert.NoError(t, err)
+		assert.True(t, found)
+		assert.Equal(t, tt.expected, identity, string(tt.source))
+	}
+}
+
+func TestFromClientCert_DefaultLimitAndMissing(t *testing.T) {
+	extract, err := FromClientCert(CertSANURI, nil, 5)
+	assert.NoError(t, err)
+
+	_, found, err := extract(requestWithCert(nil))
+	assert.NoError(t, err)
+	assert.False(t, found, "plain requests have no certificate identity")
+
+	_, _, err = extract(requestWithCert(&x509.Certificate{Subject: pkix.Name{CommonName: "billing"}}))
+	assert.Error(t, err, "a certificate without SAN URI can't be identified by it")
+
+	extract, err = FromClientCert(CertSubjectCN, nil, 5)
+	assert.NoError(t, err)
+	identity, _, err := extract(requestWithCert(&x509.Certificate{Subject: pkix.Name{CommonName: "other"}}))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(5), identity.Limit)
+
+	_, err = FromClientCert("serial", nil, 5)
+	assert.Error(t, err)
+}
@@ -0,0 +1,220 @@
+package middleware
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	limiter "github.com/jpodlasnisky/ratelimiter/ratelimiter"
+)
+
+// maxKeyPartLength is the longest attribute kept as is in a key; longer ones
+// are replaced by their hash, so a header can't make huge keys.
+const maxKeyPartLength = 128
+
+// KeyTemplate builds a key from attributes of the request, such as
+// "{token}|{method}|{path}" or "{ip}|{header:User-Agent}". The attributes are
+// {ip}, {token}, {method}, {path}, {header:<name>}, {param:<name>}, taken from
+// the path pattern of the rule, and {claim:<name>}, from the verified JWT.
+type KeyTemplate struct {
+	source string
+	parts  []templatePart
+}
+
+type templatePart struct {
+	literal string
+	attr    string
+	name    string
+}
+
+func ParseKeyTemplate(source string) (KeyTemplate, error) {
+	t := KeyTemplate{source: source}
+
+	rest := source
+	for rest != "" {
+		open := strings.IndexByte(rest, '{')
+		if open < 0 {
+			if strings.ContainsRune(rest, '}') {
+				return KeyTemplate{}, fmt.Errorf("invalid key template %q: unexpected }", source)
+			}
+			t.parts = append(t.parts, templatePart{literal: rest})
+			break
+		}
+
+		if literal := rest[:open]; literal != "" {
+			if strings.ContainsRune(literal, '}') {
+				return KeyTemplate{}, fmt.Errorf("invalid key template %q: unexpected }", source)
+			}
+			t.parts = append(t.parts, templatePart{literal: literal})
+		}
+
+		end := strings.IndexByte(rest[open:], '}')
+		if end < 0 {
+			return KeyTemplate{}, fmt.Errorf("invalid key template %q: missing }", source)
+		}
+
+		attr, name, _ := strings.Cut(rest[open+1:open+end], ":")
+		switch {
+		case attr == "ip", attr == "token", attr == "method", attr == "path":
+			if name != "" {
+				return KeyTemplate{}, fmt.Errorf("invalid key template %q: {%s} takes no name", source, attr)
+			}
+		case (attr == "header" || attr == "param" || attr == "claim") && name != "":
+		default:
+			return KeyTemplate{}, fmt.Errorf("invalid key template %q: unknown attribute %q", source, rest[open+1:open+end])
+		}
+
+		t.parts = append(t.parts, templatePart{attr: attr, name: name})
+		rest = rest[open+end+1:]
+	}
+
+	if len(t.parts) == 0 {
+		return KeyTemplate{}, fmt.Errorf("invalid key template %q: empty", source)
+	}
+	return t, nil
+}
+
+func (t KeyTemplate) String() string {
+	return t.source
+}
+
+// requestAttributes are the values a template can read from a request.
+type requestAttributes struct {
+	r      *http.Request
+	ip     string
+	token  string
+	params map[string]string
+	claims map[string]string
+}
+
+func (a requestAttributes) value(attr, name string) string {
+	switch attr {
+	case "ip":
+		return a.ip
+	case "token":
+		return a.token
+	case "method":
+		return a.r.Method
+	case "path":
+		return a.r.URL.Path
+	case "header":
+		return a.r.Header.Get(name)
+	case "param":
+		return a.params[name]
+	case "claim":
+		return a.claims[name]
+	}
+	return ""
+}
+
+// render builds the key of the request. It returns false when an attribute
+// is missing, so the rule doesn't apply.
+func (t KeyTemplate) render(attrs requestAttributes) (string, bool) {
+	var key strings.Builder
+	for _, part := range t.parts {
+		if part.attr == "" {
+			key.WriteString(part.literal)
+			continue
+		}
+
+		value := attrs.value(part.attr, part.name)
+		if value == "" {
+			return "", false
+		}
+		key.WriteString(escapeKeyPart(value))
+	}
+	return key.String(), true
+}
+
+// escapeKeyPart keeps the separators of the template and the braces of the
+// Redis hash tags out of a value, so two requests can't build the same key
+// from different attributes.
+func escapeKeyPart(value string) string {
+	escaped := url.QueryEscape(value)
+	if len(escaped) > maxKeyPartLength {
+		sum := sha256.Sum256([]byte(value))
+		return "sha256-" + hex.EncodeToString(sum[:])
+	}
+	return escaped
+}
+
+// KeyRule limits the requests whose path matches Pattern by the key built by
+// Template, in addition to the token or IP limit.
+type KeyRule struct {
+	// Pattern matches the path by segments, as a prefix: "/v1/orders/{id}"
+	// matches "/v1/orders/42/items" with the param id=42. "/" matches all.
+	Pattern  string
+	Template KeyTemplate
+	Limit    int64
+}
+
+// ParseKeyRules builds the rules from specs like
+// "/v1/orders/{id} {token}|{param:id} 10", kept in the given order.
+func ParseKeyRules(specs []string) ([]KeyRule, error) {
+	rules := make([]KeyRule, 0, len(specs))
+	for _, spec := range specs {
+		fields := strings.Fields(spec)
+		if len(fields) != 3 || !strings.HasPrefix(fields[0], "/") {
+			return nil, fmt.Errorf("invalid key rule %q", spec)
+		}
+
+		template, err := ParseKeyTemplate(fields[1])
+		if err != nil {
+			return nil, err
+		}
+
+		limit, err := strconv.ParseInt(fields[2], 10, 64)
+		if err != nil || limit <= 0 {
+			return nil, fmt.Errorf("invalid key rule %q: invalid limit", spec)
+		}
+
+		rules = append(rules, KeyRule{Pattern: fields[0], Template: template, Limit: limit})
+	}
+	return rules, nil
+}
+
+// match reports whether the path matches the pattern and returns its params.
+func (rule KeyRule) match(path string) (map[string]string, bool) {
+	params := map[string]string{}
+	patternSegments := splitPath(rule.Pattern)
+	pathSegments := splitPath(path)
+	if len(pathSegments) < len(patternSegments) {
+		return nil, false
+	}
+
+	for i, segment := range patternSegments {
+		if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+			params[segment[1:len(segment)-1]] = pathSegments[i]
+		} else if segment != pathSegments[i] {
+			return nil, false
+		}
+	}
+	return params, true
+}
+
+// key returns the key of the request for the rule, or false when it doesn't apply.
+func (rule KeyRule) key(attrs requestAttributes) (string, bool) {
+	params, matched := rule.match(attrs.r.URL.Path)
+	if !matched {
+		return "", false
+	}
+	attrs.params = params
+
+	rendered, ok := rule.Template.render(attrs)
+	if !ok {
+		return "", false
+	}
+	return limiter.KindKeyRule.Key(rule.Pattern+" "+rule.Template.source, rendered), true
+}
+
+func splitPath(path string) []string {
+	trimmed := strings.Trim(path, "/")
+	if trimmed == "" {
+		return nil
+	}
+	return strings.Split(trimmed, "/")
+}
@@ -0,0 +1,92 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseKeyTemplate(t *testing.T) {
+	for _, source := range []string{"{token}|{method}", "{ip}:{header:User-Agent}", "{claim:tenant_id}/{param:id}", "fixed"} {
+		_, err := ParseKeyTemplate(source)
+		assert.NoError(t, err, source)
+	}
+
+	for _, source := range []string{"", "{token", "token}", "{user}", "{header}", "{ip:x}", "{header:}"} {
+		_, err := ParseKeyTemplate(source)
+		assert.Error(t, err, source)
+	}
+}
+
+func TestKeyRule_Key(t *testing.T) {
+	rules, err := ParseKeyRules([]string{"/v1/orders/{id} {token}|{param:id}|{method} 10"})
+	assert.NoError(t, err)
+	rule := rules[0]
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/orders/42/items", nil)
+	key, applies := rule.key(requestAttributes{r: req, token: "TOKEN_1"})
+	assert.True(t, applies)
+	assert.Equal(t, "rule:%2Fv1%2Forders%2F%7Bid%7D+%7Btoken%7D%7C%7Bparam%3Aid%7D%7C%7Bmethod%7D:TOKEN_1%7C42%7CPOST", key)
+
+	_, applies = rule.key(requestAttributes{r: httptest.NewRequest(http.MethodPost, "/v1/users/42", nil), token: "TOKEN_1"})
+	assert.False(t, applies, "other paths don't match the pattern")
+
+	_, applies = rule.key(requestAttributes{r: req})
+	assert.False(t, applies, "requests without token don't have the key")
+}
+
+func TestKeyTemplate_Escaping(t *testing.T) {
+	template, err := ParseKeyTemplate("{header:A}|{header:B}")
+	assert.NoError(t, err)
+
+	first := httptest.NewRequest(http.MethodGet, "/", nil)
+	first.Header.Set("A", "x|y")
+	first.Header.Set("B", "z")
+
+	second := httptest.NewRequest(http.MethodGet, "/", nil)
+	second.Header.Set("A", "x")
+	second.Header.Set("B", "y|z")
+
+	firstKey, _ := template.render(requestAttributes{r: first})
+	secondKey, _ := template.render(requestAttributes{r: second})
+	assert.NotEqual(t, firstKey, secondKey, "a value must not be able to forge the separators")
+
+	tagged := httptest.NewRequest(http.MethodGet, "/", nil)
+	tagged.Header.Set("A", "{slot}")
+	tagged.Header.Set("B", string(make([]byte, 500)))
+	key, _ := template.render(requestAttributes{r: tagged})
+	assert.NotContains(t, key, "{", "values must not open Redis hash tags")
+	assert.Less(t, len(key), 200, "long values must be hashed")
+}
+
+func TestParseKeyRules_Invalid(t *testing.T) {
+	for _, spec := range []string{"{token} 10", "/v1 {token}", "/v1 {token} 0", "v1 {token} 10", "/v1 {nope} 10"} {
+		_, err := ParseKeyRules([]string{spec})
+		assert.Error(t, err, spec)
+	}
+}
+
+func TestRateLimitMiddleware_KeyRules(t *testing.T) {
+	rateLimiter := newTestLimiter(t, 100)
+
+	rules, err := ParseKeyRules([]string{"/ {ip}|{header:User-Agent} 2"})
+	assert.NoError(t, err)
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	handler := RateLimitMiddleware(next, rateLimiter, WithKeyRules(rules...))
+
+	serve := func(userAgent string) int {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("User-Agent", userAgent)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	assert.Equal(t, http.StatusOK, serve("curl"))
+	assert.Equal(t, http.StatusOK, serve("curl"))
+	assert.Equal(t, http.StatusTooManyRequests, serve("curl"))
+	assert.Equal(t, http.StatusOK, serve("browser"), "each user agent of the IP must get its own budget")
+}
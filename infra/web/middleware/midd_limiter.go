@@ -1,17 +1,153 @@
 package middleware
 
 import (
+	"errors"
 	"net/http"
 	"strings"
+	"time"
 
 	limiter "github.com/jpodlasnisky/ratelimiter/ratelimiter"
 )
 
-func RateLimitMiddleware(next http.Handler, rateLimiter *limiter.RateLimiter) http.Handler {
+// InvalidTokenMode decides what happens to a request with a token the limiter
+// doesn't know.
+type InvalidTokenMode string
+
+const (
+	// InvalidTokenAsIP limits the request by IP, as if it had no token.
+	InvalidTokenAsIP InvalidTokenMode = "ip"
+
+	// InvalidTokenReject answers 401 Unauthorized.
+	InvalidTokenReject InvalidTokenMode = "reject"
+
+	// InvalidTokenPenalty limits the request by IP, counting it as several requests.
+	InvalidTokenPenalty InvalidTokenMode = "penalty"
+)
+
+// Identity is who a request is limited as, when it is known by other means
+// than a registered token, e.g. a verified JWT.
+type Identity struct {
+	Key string
+
+	// Limit is the number of requests per window of the identity. Zero means
+	// the IP limit.
+	Limit int64
+
+	// Claims are the attributes of the identity a key template can read.
+	Claims map[string]string
+}
+
+// IdentityExtractor finds the identity of a request. It returns false when
+// the request carries none, and an error when it carries an invalid one,
+// which is answered with 401.
+type IdentityExtractor func(r *http.Request) (Identity, bool, error)
+
+type options struct {
+	identityExtractors []IdentityExtractor
+	extractors         []TokenExtractor
+	invalidTokenMode   InvalidTokenMode
+	invalidPenalty     int
+	maxFailedAttempts  int64
+	failedWindow       time.Duration
+	keyRules           []KeyRule
+}
+
+type Option func(*options)
+
+// WithIdentityExtractors sets the extractors tried, in order, before the
+// token extractors.
+func WithIdentityExtractors(extractors ...IdentityExtractor) Option {
+	return func(o *options) {
+		o.identityExtractors = extractors
+	}
+}
+
+// WithTokenExtractors sets where the token is read from, in priority order.
+func WithTokenExtractors(extractors ...TokenExtractor) Option {
+	return func(o *options) {
+		if len(extractors) > 0 {
+			o.extractors = extractors
+		}
+	}
+}
+
+// WithInvalidTokenMode sets what happens to unknown tokens. In penalty mode
+// each such request counts as penalty requests of its IP.
+func WithInvalidTokenMode(mode InvalidTokenMode, penalty int) Option {
+	return func(o *options) {
+		o.invalidTokenMode = mode
+		o.invalidPenalty = penalty
+	}
+}
+
+// WithFailedTokenLimit blocks an IP that sends more than maxAttempts unknown
+// tokens within window, to stop key enumeration. Zero disables the limit.
+func WithFailedTokenLimit(maxAttempts int64, window time.Duration) Option {
+	return func(o *options) {
+		o.maxFailedAttempts = maxAttempts
+		o.failedWindow = window
+	}
+}
+
+// WithKeyRules adds limits by keys built from attributes of the request,
+// checked after the token or IP limit.
+func WithKeyRules(rules ...KeyRule) Option {
+	return func(o *options) {
+		o.keyRules = rules
+	}
+}
+
+// RateLimitMiddleware limits the requests by token or, when there is none, by
+// IP. Without options the token is read from the API_KEY header and unknown
+// tokens are limited by IP.
+func RateLimitMiddleware(next http.Handler, rateLimiter *limiter.RateLimiter, opts ...Option) http.Handler {
+	o := &options{
+		extractors:       DefaultTokenExtractors,
+		invalidTokenMode: InvalidTokenAsIP,
+		invalidPenalty:   1,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		token := r.Header.Get("API_KEY")
+		identity, found, err := o.identify(r)
+		if err != nil {
+			http.Error(w, "Unauthorized: "+err.Error(), http.StatusUnauthorized)
+			return
+		}
+
+		token := extractToken(r, o.extractors)
+		ip := strings.Split(r.RemoteAddr, ":")[0]
+
+		if found {
+
+			limit := identity.Limit
+			if limit <= 0 {
+				limit = rateLimiter.IPLimit()
+			}
+
+			decision, err := rateLimiter.CheckN(r.Context(), identity.Key, limit, 1)
+			if err != nil {
+				http.Error(w, "Internal Server Error: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
 
-		if token != "" && rateLimiter.TokenExists(token) {
+			if !decision.Allowed {
+				http.Error(w, "Your identity have reached the maximum number of requests or actions allowed within a certain time frame.", http.StatusTooManyRequests)
+				return
+			}
+
+		} else if token != "" && rateLimiter.TokenExists(token) {
+
+			switch err := rateLimiter.CheckTokenSchedule(token); {
+			case errors.Is(err, limiter.ErrTokenExpired):
+				http.Error(w, "Unauthorized: API key expired.", http.StatusUnauthorized)
+				return
+			case errors.Is(err, limiter.ErrTokenNotYetValid):
+				http.Error(w, "Unauthorized: API key not valid yet.", http.StatusUnauthorized)
+				return
+			}
 
 			isBlocked, err := rateLimiter.CheckRateLimitForKey(r.Context(), token, true)
 			if err != nil {
@@ -26,7 +162,10 @@ func RateLimitMiddleware(next http.Handler, rateLimiter *limiter.RateLimiter) ht
 
 		} else {
 
-			ip := strings.Split(r.RemoteAddr, ":")[0]
+			if token != "" && !o.handleInvalidToken(w, r, rateLimiter, ip) {
+				return
+			}
+
 			isBlocked, err := rateLimiter.CheckRateLimitForKey(r.Context(), ip, false)
 			if err != nil {
 				http.Error(w, "Internal Server Error: "+err.Error(), http.StatusInternalServerError)
@@ -39,6 +178,96 @@ func RateLimitMiddleware(next http.Handler, rateLimiter *limiter.RateLimiter) ht
 			}
 		}
 
+		attrs := requestAttributes{r: r, ip: ip, token: token, claims: identity.Claims}
+		if !o.checkKeyRules(w, r, rateLimiter, attrs) {
+			return
+		}
+
 		next.ServeHTTP(w, r)
 	})
 }
+
+// checkKeyRules counts the request against every rule that applies to it and
+// reports whether all of them still allow it.
+func (o *options) checkKeyRules(w http.ResponseWriter, r *http.Request, rateLimiter *limiter.RateLimiter, attrs requestAttributes) bool {
+	for _, rule := range o.keyRules {
+		key, applies := rule.key(attrs)
+		if !applies {
+			continue
+		}
+
+		decision, err := rateLimiter.CheckN(r.Context(), key, rule.Limit, 1)
+		if err != nil {
+			http.Error(w, "Internal Server Error: "+err.Error(), http.StatusInternalServerError)
+			return false
+		}
+
+		if !decision.Allowed {
+			http.Error(w, "Your request have reached the maximum number of requests or actions allowed within a certain time frame for "+rule.Template.String()+".", http.StatusTooManyRequests)
+			return false
+		}
+	}
+	return true
+}
+
+func (o *options) identify(r *http.Request) (Identity, bool, error) {
+	for _, extract := range o.identityExtractors {
+		identity, found, err := extract(r)
+		if err != nil || found {
+			return identity, found, err
+		}
+	}
+	return Identity{}, false, nil
+}
+
+// handleInvalidToken counts the unknown token of the IP and applies the
+// invalid token mode. It reports whether the request goes on to the IP check,
+// which counts one more request.
+func (o *options) handleInvalidToken(w http.ResponseWriter, r *http.Request, rateLimiter *limiter.RateLimiter, ip string) bool {
+	if !o.countFailedToken(w, r, rateLimiter, ip) {
+		return false
+	}
+
+	switch o.invalidTokenMode {
+	case InvalidTokenReject:
+		http.Error(w, "Unauthorized: invalid API key.", http.StatusUnauthorized)
+		return false
+
+	case InvalidTokenPenalty:
+		if o.invalidPenalty <= 1 {
+			return true
+		}
+
+		decision, err := rateLimiter.CheckN(r.Context(), ip, rateLimiter.IPLimit(), o.invalidPenalty-1)
+		if err != nil {
+			http.Error(w, "Internal Server Error: "+err.Error(), http.StatusInternalServerError)
+			return false
+		}
+
+		if !decision.Allowed {
+			http.Error(w, "Your IP have reached the maximum number of requests or actions allowed within a certain time frame.", http.StatusTooManyRequests)
+			return false
+		}
+	}
+	return true
+}
+
+// countFailedToken counts an unknown token sent by the IP and reports whether
+// the IP is still below the limit of failed attempts.
+func (o *options) countFailedToken(w http.ResponseWriter, r *http.Request, rateLimiter *limiter.RateLimiter, ip string) bool {
+	if o.maxFailedAttempts <= 0 {
+		return true
+	}
+
+	decision, err := rateLimiter.CheckNInWindow(r.Context(), limiter.KindFailedToken.Key(ip), o.maxFailedAttempts, 1, o.failedWindow)
+	if err != nil {
+		http.Error(w, "Internal Server Error: "+err.Error(), http.StatusInternalServerError)
+		return false
+	}
+
+	if !decision.Allowed {
+		http.Error(w, "Your IP have sent too many invalid API keys.", http.StatusTooManyRequests)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,128 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/jpodlasnisky/ratelimiter/infra/database"
+	"github.com/jpodlasnisky/ratelimiter/infra/database/contract_db"
+	limiter "github.com/jpodlasnisky/ratelimiter/ratelimiter"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestLimiter(t *testing.T, ipLimit int64) *limiter.RateLimiter {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+
+	rateLimiter := limiter.New(contract_db.NewDatastoreStore(database.NewRedisDataLimiter(client)),
+		limiter.WithPolicies(map[string]int64{"TOKEN_1": 2}),
+		limiter.WithIPLimit(ipLimit),
+		limiter.WithWindow(time.Minute),
+	)
+	assert.NoError(t, rateLimiter.RegisterPersonalizedTokens(context.Background()))
+	return rateLimiter
+}
+
+func serveWithToken(handler http.Handler, token string, times int) []int {
+	codes := []int{}
+	for i := 0; i < times; i++ {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if token != "" {
+			req.Header.Set("API_KEY", token)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		codes = append(codes, rec.Code)
+	}
+	return codes
+}
+
+var okHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+func TestRateLimitMiddleware_InvalidTokenAsIP(t *testing.T) {
+	handler := RateLimitMiddleware(okHandler, newTestLimiter(t, 2))
+
+	assert.Equal(t, []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests}, serveWithToken(handler, "TYPO", 3))
+}
+
+func TestRateLimitMiddleware_InvalidTokenReject(t *testing.T) {
+	handler := RateLimitMiddleware(okHandler, newTestLimiter(t, 2), WithInvalidTokenMode(InvalidTokenReject, 0))
+
+	assert.Equal(t, []int{http.StatusUnauthorized, http.StatusUnauthorized}, serveWithToken(handler, "TYPO", 2))
+	assert.Equal(t, []int{http.StatusOK, http.StatusOK}, serveWithToken(handler, "", 2), "rejected requests must not use up the IP limit")
+}
+
+func TestRateLimitMiddleware_InvalidTokenPenalty(t *testing.T) {
+	handler := RateLimitMiddleware(okHandler, newTestLimiter(t, 3), WithInvalidTokenMode(InvalidTokenPenalty, 2))
+
+	assert.Equal(t, []int{http.StatusOK, http.StatusTooManyRequests}, serveWithToken(handler, "TYPO", 2))
+}
+
+func TestRateLimitMiddleware_FailedTokenLimit(t *testing.T) {
+	handler := RateLimitMiddleware(okHandler, newTestLimiter(t, 100),
+		WithInvalidTokenMode(InvalidTokenReject, 0),
+		WithFailedTokenLimit(2, time.Minute),
+	)
+
+	assert.Equal(t, []int{http.StatusUnauthorized, http.StatusUnauthorized, http.StatusTooManyRequests, http.StatusTooManyRequests},
+		serveWithToken(handler, "GUESS", 4))
+	assert.Equal(t, []int{http.StatusOK}, serveWithToken(handler, "TOKEN_1", 1), "valid tokens are not counted as failed attempts")
+}
+
+func TestRateLimitMiddleware_Identity(t *testing.T) {
+	identity := func(r *http.Request) (Identity, bool, error) {
+		switch r.Header.Get("Authorization") {
+		case "valid":
+			return Identity{Key: "jwt:acme", Limit: 3}, true, nil
+		case "invalid":
+			return Identity{}, false, errors.New("bad signature")
+		}
+		return Identity{}, false, nil
+	}
+	handler := RateLimitMiddleware(okHandler, newTestLimiter(t, 1), WithIdentityExtractors(identity))
+
+	serve := func(authorization string) int {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Authorization", authorization)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, serve("invalid"))
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, http.StatusOK, serve("valid"))
+	}
+	assert.Equal(t, http.StatusTooManyRequests, serve("valid"))
+	assert.Equal(t, http.StatusOK, serve(""), "requests without identity must fall back to the IP limit")
+}
+
+func TestRateLimitMiddleware_TokenSchedule(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+
+	rateLimiter := limiter.New(contract_db.NewDatastoreStore(database.NewRedisDataLimiter(client)),
+		limiter.WithPolicies(map[string]int64{"EXPIRED": 2, "FUTURE": 2, "ACTIVE": 2}),
+		limiter.WithTokenSchedules(map[string]limiter.TokenSchedule{
+			"EXPIRED": {ExpiresAt: time.Now().Add(-time.Minute)},
+			"FUTURE":  {NotBefore: time.Now().Add(time.Hour)},
+			"ACTIVE":  {ExpiresAt: time.Now().Add(time.Hour)},
+		}),
+		limiter.WithIPLimit(5),
+		limiter.WithWindow(time.Minute),
+	)
+	assert.NoError(t, rateLimiter.RegisterPersonalizedTokens(context.Background()))
+
+	handler := RateLimitMiddleware(http.HandlerFunc(okHandler), rateLimiter)
+
+	assert.Equal(t, []int{http.StatusUnauthorized}, serveWithToken(handler, "EXPIRED", 1), "an expired key must not fall back to the IP limit")
+	assert.Equal(t, []int{http.StatusUnauthorized}, serveWithToken(handler, "FUTURE", 1))
+	assert.Equal(t, []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests}, serveWithToken(handler, "ACTIVE", 3))
+	assert.True(t, mr.TTL("ACTIVE") > 0, "the policy of a key with expiry must expire with it")
+}
@@ -22,7 +22,7 @@ func LoggingMiddleware(next http.Handler) http.Handler {
 		log.Printf(
 			"%s %s %s %d %s",
 			r.Method,
-			r.RequestURI,
+			redactedRequestURI(r),
 			r.RemoteAddr,
 			sw.status,
 			time.Since(startTime),
@@ -34,3 +34,33 @@ func (w *statusResponseWriter) WriteHeader(status int) {
 	w.status = status
 	w.ResponseWriter.WriteHeader(status)
 }
+
+// Unwrap lets http.ResponseController reach the Flusher and Hijacker of the
+// original writer, so streaming responses keep working behind the logger.
+func (w *statusResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// redactedRequestURI is the RequestURI with the values of the query params
+// read by FromQuery replaced, so tokens are never logged.
+func redactedRequestURI(r *http.Request) string {
+	if r.URL.RawQuery == "" {
+		return r.RequestURI
+	}
+
+	query := r.URL.Query()
+	redacted := false
+	for param := range query {
+		if _, sensitive := sensitiveQueryParams.Load(param); sensitive {
+			query.Set(param, "REDACTED")
+			redacted = true
+		}
+	}
+	if !redacted {
+		return r.RequestURI
+	}
+
+	u := *r.URL
+	u.RawQuery = query.Encode()
+	return u.RequestURI()
+}
@@ -0,0 +1,90 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// TokenExtractor reads the token of a request, returning "" when it has none.
+type TokenExtractor func(r *http.Request) string
+
+// sensitiveQueryParams are the query params that carry tokens.
+var sensitiveQueryParams sync.Map
+
+// DefaultTokenExtractors keeps the original behaviour: the API_KEY header.
+var DefaultTokenExtractors = []TokenExtractor{FromHeader("API_KEY")}
+
+func FromHeader(name string) TokenExtractor {
+	return func(r *http.Request) string {
+		return r.Header.Get(name)
+	}
+}
+
+// FromBearer reads the token of an "Authorization: Bearer <token>" header.
+func FromBearer() TokenExtractor {
+	return func(r *http.Request) string {
+		scheme, token, found := strings.Cut(r.Header.Get("Authorization"), " ")
+		if !found || !strings.EqualFold(scheme, "Bearer") {
+			return ""
+		}
+		return strings.TrimSpace(token)
+	}
+}
+
+// FromQuery reads the token of a query param. The param is redacted by
+// LoggingMiddleware, so the token doesn't end up in the access log.
+func FromQuery(param string) TokenExtractor {
+	sensitiveQueryParams.Store(param, true)
+	return func(r *http.Request) string {
+		return r.URL.Query().Get(param)
+	}
+}
+
+func FromCookie(name string) TokenExtractor {
+	return func(r *http.Request) string {
+		cookie, err := r.Cookie(name)
+		if err != nil {
+			return ""
+		}
+		return cookie.Value
+	}
+}
+
+// extractToken returns the token found by the first extractor that finds one.
+func extractToken(r *http.Request, extractors []TokenExtractor) string {
+	for _, extract := range extractors {
+		if token := extract(r); token != "" {
+			return token
+		}
+	}
+	return ""
+}
+
+// ParseTokenExtractors builds the extractors from specs like "header:X-API-Key",
+// "bearer", "query:api_key" or "cookie:api_key", kept in the given order.
+func ParseTokenExtractors(specs []string) ([]TokenExtractor, error) {
+	if len(specs) == 0 {
+		return DefaultTokenExtractors, nil
+	}
+
+	extractors := make([]TokenExtractor, 0, len(specs))
+	for _, spec := range specs {
+		kind, name, _ := strings.Cut(spec, ":")
+
+		switch {
+		case kind == "bearer":
+			extractors = append(extractors, FromBearer())
+		case kind == "header" && name != "":
+			extractors = append(extractors, FromHeader(name))
+		case kind == "query" && name != "":
+			extractors = append(extractors, FromQuery(name))
+		case kind == "cookie" && name != "":
+			extractors = append(extractors, FromCookie(name))
+		default:
+			return nil, fmt.Errorf("invalid token extractor %q", spec)
+		}
+	}
+	return extractors, nil
+}
@@ -0,0 +1,85 @@
+package middleware
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTokenExtractors(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/?api_key=from-query", nil)
+	req.Header.Set("API_KEY", "from-header")
+	req.Header.Set("Authorization", "Bearer from-bearer")
+	req.AddCookie(&http.Cookie{Name: "api_key", Value: "from-cookie"})
+
+	assert.Equal(t, "from-header", FromHeader("API_KEY")(req))
+	assert.Equal(t, "from-bearer", FromBearer()(req))
+	assert.Equal(t, "from-query", FromQuery("api_key")(req))
+	assert.Equal(t, "from-cookie", FromCookie("api_key")(req))
+
+	empty := httptest.NewRequest(http.MethodGet, "/", nil)
+	empty.Header.Set("Authorization", "Basic dXNlcjpwYXNz")
+	assert.Empty(t, FromHeader("API_KEY")(empty))
+	assert.Empty(t, FromBearer()(empty))
+	assert.Empty(t, FromQuery("api_key")(empty))
+	assert.Empty(t, FromCookie("api_key")(empty))
+}
+
+func TestParseTokenExtractors(t *testing.T) {
+	extractors, err := ParseTokenExtractors([]string{"query:api_key", "bearer", "header:X-API-Key"})
+	assert.NoError(t, err)
+	assert.Len(t, extractors, 3)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("X-API-Key", "from-header")
+	req.Header.Set("Authorization", "Bearer from-bearer")
+	assert.Equal(t, "from-bearer", extractToken(req, extractors), "the first extractor that finds a token wins")
+
+	extractors, err = ParseTokenExtractors(nil)
+	assert.NoError(t, err)
+	assert.Len(t, extractors, 1)
+
+	for _, spec := range []string{"header", "query:", "jwt"} {
+		_, err := ParseTokenExtractors([]string{spec})
+		assert.Error(t, err, spec)
+	}
+}
+
+func TestRateLimitMiddleware_Extractors(t *testing.T) {
+	rateLimiter := newTestLimiter(t, 1)
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	handler := RateLimitMiddleware(next, rateLimiter, WithTokenExtractors(FromBearer()))
+
+	codes := []int{}
+	for i := 0; i < 3; i++ {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Authorization", "Bearer TOKEN_1")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		codes = append(codes, rec.Code)
+	}
+
+	assert.Equal(t, []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests}, codes, "the bearer token must get its own limit, not the IP one")
+}
+
+func TestLoggingMiddleware_RedactsQueryToken(t *testing.T) {
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	extract := FromQuery("api_key")
+	handler := LoggingMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "SECRET_TOKEN", extract(r))
+	}))
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/v1/orders?page=2&api_key=SECRET_TOKEN", nil))
+
+	assert.NotContains(t, logs.String(), "SECRET_TOKEN")
+	assert.Contains(t, logs.String(), "/v1/orders?api_key=REDACTED&page=2")
+}
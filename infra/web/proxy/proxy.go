@@ -0,0 +1,153 @@
+// Package proxy runs the limiter as a gateway: requests that pass the
+// middleware are forwarded to the upstream service of their route.
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Route sends the requests whose path starts with Prefix to Upstream. The
+// path is forwarded unchanged.
+type Route struct {
+	Prefix   string
+	Upstream *url.URL
+}
+
+// ParseRoutes reads routes written as prefix=url, like "/api=http://api:8080".
+func ParseRoutes(specs []string) ([]Route, error) {
+	routes := make([]Route, 0, len(specs))
+	for _, spec := range specs {
+		prefix, rawURL, found := strings.Cut(spec, "=")
+		if !found || !strings.HasPrefix(prefix, "/") {
+			return nil, fmt.Errorf("invalid proxy route %q: expected /prefix=url", spec)
+		}
+
+		upstream, err := url.Parse(rawURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy route %q: %w", spec, err)
+		}
+		if upstream.Scheme == "" || upstream.Host == "" {
+			return nil, fmt.Errorf("invalid proxy route %q: upstream must be an absolute URL", spec)
+		}
+
+		routes = append(routes, Route{Prefix: prefix, Upstream: upstream})
+	}
+	return routes, nil
+}
+
+type upstream struct {
+	Route
+	proxy   *httputil.ReverseProxy
+	healthy atomic.Bool
+}
+
+// Proxy forwards each request to the route with the longest matching prefix.
+type Proxy struct {
+	upstreams []*upstream
+	client    *http.Client
+}
+
+func New(routes []Route) *Proxy {
+	p := &Proxy{client: &http.Client{Timeout: 2 * time.Second}}
+
+	for _, route := range routes {
+		u := &upstream{Route: route, proxy: httputil.NewSingleHostReverseProxy(route.Upstream)}
+
+		// Repassa cada escrita na hora, para não segurar respostas em streaming.
+		u.proxy.FlushInterval = -1
+		u.proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+			log.Printf("Erro ao encaminhar %s para %s: %v", r.URL.Path, u.Upstream, err)
+			http.Error(w, "Bad Gateway", http.StatusBadGateway)
+		}
+
+		u.healthy.Store(true)
+		p.upstreams = append(p.upstreams, u)
+	}
+
+	sort.SliceStable(p.upstreams, func(i, j int) bool {
+		return len(p.upstreams[i].Prefix) > len(p.upstreams[j].Prefix)
+	})
+	return p
+}
+
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	u := p.match(r.URL.Path)
+	if u == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	if !u.healthy.Load() {
+		http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+		return
+	}
+
+	u.proxy.ServeHTTP(w, r)
+}
+
+// match returns the upstream of the longest prefix that matches whole path
+// segments, so /api matches /api and /api/orders but not /apiary.
+func (p *Proxy) match(path string) *upstream {
+	for _, u := range p.upstreams {
+		if path == u.Prefix || strings.HasPrefix(path, strings.TrimSuffix(u.Prefix, "/")+"/") {
+			return u
+		}
+	}
+	return nil
+}
+
+// StartHealthChecks asks every upstream for path on each interval, until the
+// context is done. An upstream that fails to answer or answers with a 5xx is
+// taken out of rotation until it answers again.
+func (p *Proxy) StartHealthChecks(ctx context.Context, path string, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		p.CheckHealth(ctx, path)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// CheckHealth checks every upstream once.
+func (p *Proxy) CheckHealth(ctx context.Context, path string) {
+	for _, u := range p.upstreams {
+		healthy := p.isHealthy(ctx, u.Upstream.JoinPath(path).String())
+		if u.healthy.Swap(healthy) != healthy {
+			log.Printf("Upstream %s healthy: %t", u.Upstream, healthy)
+		}
+	}
+}
+
+func (p *Proxy) isHealthy(ctx context.Context, healthURL string) bool {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, healthURL, nil)
+	if err != nil {
+		return false
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+
+	return resp.StatusCode < http.StatusInternalServerError
+}
@@ -0,0 +1,144 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newUpstream(t *testing.T, name string) *httptest.Server {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%s %s", name, r.URL.Path)
+	}))
+	t.Cleanup(upstream.Close)
+	return upstream
+}
+
+func get(t *testing.T, handler http.Handler, path string) (int, string) {
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	body, _ := io.ReadAll(rec.Body)
+	return rec.Code, string(body)
+}
+
+func TestParseRoutes(t *testing.T) {
+	routes, err := ParseRoutes([]string{"/api=http://api:8080", "/=http://web:3000"})
+	assert.NoError(t, err)
+	assert.Len(t, routes, 2)
+	assert.Equal(t, "/api", routes[0].Prefix)
+	assert.Equal(t, "api:8080", routes[0].Upstream.Host)
+
+	for _, spec := range []string{"api=http://api", "/api", "/api=api:8080"} {
+		_, err := ParseRoutes([]string{spec})
+		assert.Error(t, err, spec)
+	}
+}
+
+func TestProxy_LongestPrefix(t *testing.T) {
+	api := newUpstream(t, "api")
+	web := newUpstream(t, "web")
+
+	routes, err := ParseRoutes([]string{"/=" + web.URL, "/api=" + api.URL})
+	assert.NoError(t, err)
+	p := New(routes)
+
+	code, body := get(t, p, "/api/orders")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "api /api/orders", body)
+
+	code, body = get(t, p, "/index.html")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "web /index.html", body)
+
+	code, body = get(t, p, "/api")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "api /api", body)
+
+	code, body = get(t, p, "/apiary")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "web /apiary", body, "a prefix must match whole path segments")
+}
+
+func TestProxy_NoRoute(t *testing.T) {
+	api := newUpstream(t, "api")
+
+	routes, err := ParseRoutes([]string{"/api=" + api.URL})
+	assert.NoError(t, err)
+
+	code, _ := get(t, New(routes), "/other")
+	assert.Equal(t, http.StatusNotFound, code)
+
+	code, _ = get(t, New(routes), "/apiary")
+	assert.Equal(t, http.StatusNotFound, code)
+}
+
+func TestProxy_HealthCheck(t *testing.T) {
+	var healthy atomic.Bool
+	healthy.Store(true)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/healthz" && !healthy.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprint(w, "ok")
+	}))
+	defer upstream.Close()
+
+	routes, err := ParseRoutes([]string{"/=" + upstream.URL})
+	assert.NoError(t, err)
+	p := New(routes)
+
+	healthy.Store(false)
+	p.CheckHealth(context.Background(), "/healthz")
+	code, _ := get(t, p, "/")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+
+	healthy.Store(true)
+	p.CheckHealth(context.Background(), "/healthz")
+	code, _ = get(t, p, "/")
+	assert.Equal(t, http.StatusOK, code)
+}
+
+func TestProxy_UpstreamDown(t *testing.T) {
+	upstream := newUpstream(t, "down")
+	upstream.Close()
+
+	routes, err := ParseRoutes([]string{"/=" + upstream.URL})
+	assert.NoError(t, err)
+
+	code, _ := get(t, New(routes), "/")
+	assert.Equal(t, http.StatusBadGateway, code)
+}
+
+func TestProxy_Streaming(t *testing.T) {
+	release := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "first")
+		w.(http.Flusher).Flush()
+		<-release
+		fmt.Fprintln(w, "second")
+	}))
+	defer upstream.Close()
+	defer close(release)
+
+	routes, err := ParseRoutes([]string{"/=" + upstream.URL})
+	assert.NoError(t, err)
+
+	gateway := httptest.NewServer(New(routes))
+	defer gateway.Close()
+
+	resp, err := http.Get(gateway.URL)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+
+	line, err := bufio.NewReader(resp.Body).ReadString('\n')
+	assert.NoError(t, err)
+	assert.Equal(t, "first\n", line, "the first chunk must arrive before the upstream finishes")
+}
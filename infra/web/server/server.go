@@ -2,7 +2,12 @@ package server
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
@@ -11,12 +16,20 @@ import (
 
 	"github.com/jpodlasnisky/ratelimiter/config"
 	"github.com/jpodlasnisky/ratelimiter/infra/database"
+	"github.com/jpodlasnisky/ratelimiter/infra/database/contract_db"
 	"github.com/jpodlasnisky/ratelimiter/infra/web/handler"
+	"github.com/jpodlasnisky/ratelimiter/infra/web/jwtauth"
+	"github.com/jpodlasnisky/ratelimiter/infra/web/middleware"
+	"github.com/jpodlasnisky/ratelimiter/infra/web/proxy"
 	"github.com/jpodlasnisky/ratelimiter/ratelimiter"
+	"google.golang.org/grpc"
 )
 
 type Server struct {
 	*http.Server
+
+	certFile string
+	keyFile  string
 }
 
 func New(port string, handler http.Handler) *Server {
@@ -30,29 +43,270 @@ func New(port string, handler http.Handler) *Server {
 	}
 }
 
+// EnableTLS makes Start serve HTTPS with the certificate and key. With
+// clientCAFile, every client must present a certificate signed by one of its
+// CAs (mTLS).
+func (s *Server) EnableTLS(certFile, keyFile, clientCAFile string) error {
+	if _, err := tls.LoadX509KeyPair(certFile, keyFile); err != nil {
+		return fmt.Errorf("loading server certificate: %w", err)
+	}
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if clientCAFile != "" {
+		caCert, err := os.ReadFile(clientCAFile)
+		if err != nil {
+			return fmt.Errorf("reading TLS_CLIENT_CA_FILE: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caCert) {
+			return errors.New("TLS_CLIENT_CA_FILE has no valid PEM certificate")
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	s.TLSConfig = tlsConfig
+	s.certFile = certFile
+	s.keyFile = keyFile
+	return nil
+}
+
 func (s *Server) Start() {
 	log.Println("Starting server on port", s.Addr)
-	if err := s.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+
+	var err error
+	if s.certFile != "" {
+		err = s.ListenAndServeTLS(s.certFile, s.keyFile)
+	} else {
+		err = s.ListenAndServe()
+	}
+	if err != nil && err != http.ErrServerClosed {
 		log.Fatal("Error starting server:", err)
 	}
 }
 
+// NewRateLimiter builds the limiter of the configuration without registering
+// the tokens, migrating keys or starting background work, e.g. for the admin
+// command.
+func NewRateLimiter(cfg *config.Config) *ratelimiter.RateLimiter {
+	return newRateLimiter(cfg, setupDatastore(cfg))
+}
+
+func newRateLimiter(cfg *config.Config, datastore contract_db.Datastore) *ratelimiter.RateLimiter {
+
+	tenants := make(map[string]ratelimiter.Tenant, len(cfg.TokenTenants))
+	for token, tenant := range cfg.TokenTenants {
+		tenants[token] = ratelimiter.ParseTenant(tenant)
+	}
+
+	schedules := make(map[string]ratelimiter.TokenSchedule, len(cfg.TokenSchedules))
+	for token, value := range cfg.TokenSchedules {
+		schedule, err := ratelimiter.ParseTokenSchedule(value)
+		if err != nil {
+			log.Fatal("Erro ao configurar a validade do token "+token+":", err)
+		}
+		schedules[token] = schedule
+	}
+
+	return ratelimiter.New(contract_db.NewDatastoreStore(datastore),
+		ratelimiter.WithPolicies(cfg.TokenMaxRequestsPerSecond),
+		ratelimiter.WithWindow(time.Duration(cfg.LockDurationSeconds)*time.Second),
+		ratelimiter.WithBlockDuration(time.Duration(cfg.BlockDurationSeconds)*time.Second),
+		ratelimiter.WithIPLimit(int64(cfg.IPMaxRequestsPerSecond)),
+		ratelimiter.WithBlockCacheSize(cfg.BlockCacheSize),
+		ratelimiter.WithKeyHashSecret([]byte(cfg.TokenHashSecret)),
+		ratelimiter.WithNamespace(cfg.NamespaceEnv, cfg.NamespaceApp),
+		ratelimiter.WithTenants(tenants),
+		ratelimiter.WithTenantLimits(cfg.OrganizationLimits, cfg.ProjectLimits),
+		ratelimiter.WithTokenSchedules(schedules),
+		ratelimiter.WithTokenRotations(cfg.TokenRotations),
+	)
+}
+
 func SetupRateLimiter(cfg *config.Config) *ratelimiter.RateLimiter {
-	redisClient := database.NewRedisClient(cfg)
-	dbRedisDataLimiter := database.NewRedisDataLimiter(redisClient)
-	rateLimiter := ratelimiter.NewLimiter(dbRedisDataLimiter, cfg.TokenMaxRequestsPerSecond, int64(cfg.LockDurationSeconds), int64(cfg.BlockDurationSeconds), int64(cfg.IPMaxRequestsPerSecond))
+	datastore := setupDatastore(cfg)
+	rateLimiter := newRateLimiter(cfg, datastore)
+
+	if boltDataLimiter, ok := datastore.(*database.BoltDataLimiter); ok {
+		go boltDataLimiter.StartCompaction(context.Background(), time.Duration(cfg.BoltCompactionSeconds)*time.Second)
+	}
+
+	if err := rateLimiter.MigratePlaintextTokens(context.Background()); err != nil {
+		log.Fatal("Erro ao migrar os tokens para chaves em hash:", err)
+	}
 
 	if err := rateLimiter.RegisterPersonalizedTokens(context.Background()); err != nil {
 		log.Fatal("Erro ao registrar o token:", err)
 	}
 
+	go func() {
+		if err := rateLimiter.WatchBlockInvalidations(context.Background()); err != nil {
+			log.Println("Erro ao acompanhar a invalidação de bloqueios:", err)
+		}
+	}()
+
 	return rateLimiter
 }
 
+func setupDatastore(cfg *config.Config) contract_db.Datastore {
+	if cfg.Datastore == "bolt" {
+		// O bbolt trava o arquivo: só um processo por vez, servidor ou admin
+		boltDataLimiter, err := database.NewBoltDataLimiter(cfg.BoltPath)
+		if err != nil {
+			log.Fatal("Erro ao abrir o Datastore em arquivo (ele está em uso por outro processo?):", err)
+		}
+		return boltDataLimiter
+	}
+
+	redisClient, err := database.NewRedisClient(cfg)
+	if err != nil {
+		log.Fatal("Erro ao configurar o Redis:", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := database.CheckConnection(ctx, redisClient); err != nil {
+		log.Fatal("Erro ao conectar ao Redis:", err)
+	}
+
+	return database.NewRedisDataLimiter(redisClient)
+}
+
+const (
+	ModeMiddleware = "middleware"
+	ModeDecision   = "decision"
+	ModeEnvoy      = "envoy"
+	ModeProxy      = "proxy"
+)
+
 func SetupRoutes(mux *http.ServeMux) {
 	mux.HandleFunc("/", handler.RootHandler)
 }
 
+// SetupDecisionRoutes registers the decision service, used when the binary
+// answers rate limit checks for other services instead of wrapping RootHandler.
+func SetupDecisionRoutes(mux *http.ServeMux, rateLimiter *ratelimiter.RateLimiter) {
+	mux.Handle("/v1/check", handler.CheckHandler(rateLimiter))
+}
+
+// SetupMiddlewareOptions reads how the middleware finds the token and treats
+// unknown tokens.
+func SetupMiddlewareOptions(cfg *config.Config) []middleware.Option {
+	extractors, err := middleware.ParseTokenExtractors(cfg.TokenExtractors)
+	if err != nil {
+		log.Fatal("Erro ao configurar a leitura do token:", err)
+	}
+
+	mode := middleware.InvalidTokenMode(cfg.InvalidTokenMode)
+	switch mode {
+	case middleware.InvalidTokenAsIP, middleware.InvalidTokenReject, middleware.InvalidTokenPenalty:
+	default:
+		log.Fatal("Modo de token inválido desconhecido: ", cfg.InvalidTokenMode)
+	}
+
+	keyRules, err := middleware.ParseKeyRules(cfg.KeyRules)
+	if err != nil {
+		log.Fatal("Erro ao configurar as regras de chave:", err)
+	}
+
+	options := []middleware.Option{
+		middleware.WithTokenExtractors(extractors...),
+		middleware.WithKeyRules(keyRules...),
+		middleware.WithInvalidTokenMode(mode, cfg.InvalidTokenPenalty),
+		middleware.WithFailedTokenLimit(int64(cfg.InvalidTokenMaxAttempts), time.Duration(cfg.InvalidTokenWindowSeconds)*time.Second),
+	}
+
+	var identityExtractors []middleware.IdentityExtractor
+
+	if cfg.TLSClientCAFile != "" {
+		// Sem TLS não há certificado de cliente verificado para servir de chave
+		if cfg.TLSCertFile == "" {
+			log.Fatal("TLS_CLIENT_CA_FILE exige TLS_CERT_FILE e TLS_KEY_FILE")
+		}
+		certIdentity, err := middleware.FromClientCert(middleware.CertIdentitySource(cfg.MTLSIdentity), cfg.MTLSPolicies, int64(cfg.MTLSDefaultLimit))
+		if err != nil {
+			log.Fatal("Erro ao configurar a identidade do certificado do cliente:", err)
+		}
+		identityExtractors = append(identityExtractors, certIdentity)
+	}
+
+	if cfg.JWTHMACSecret != "" || cfg.JWTJWKSFile != "" {
+		verifier, err := jwtauth.NewVerifier(jwtauth.Config{
+			HMACSecret:   []byte(cfg.JWTHMACSecret),
+			JWKSFile:     cfg.JWTJWKSFile,
+			KeyClaim:     cfg.JWTKeyClaim,
+			PlanClaim:    cfg.JWTPlanClaim,
+			Plans:        cfg.JWTPlans,
+			DefaultLimit: int64(cfg.JWTDefaultLimit),
+		})
+		if err != nil {
+			log.Fatal("Erro ao configurar a validação de JWT:", err)
+		}
+		identityExtractors = append(identityExtractors, verifier.Identity)
+	}
+
+	if len(identityExtractors) > 0 {
+		options = append(options, middleware.WithIdentityExtractors(identityExtractors...))
+	}
+
+	return options
+}
+
+// SetupProxy builds the gateway to the upstreams of PROXY_ROUTES and starts
+// checking their health.
+func SetupProxy(cfg *config.Config) *proxy.Proxy {
+	routes, err := proxy.ParseRoutes(cfg.ProxyRoutes)
+	if err != nil {
+		log.Fatal("Erro ao configurar as rotas do proxy:", err)
+	}
+	if len(routes) == 0 {
+		log.Fatal("Erro ao configurar as rotas do proxy: PROXY_ROUTES está vazio")
+	}
+
+	gateway := proxy.New(routes)
+	go gateway.StartHealthChecks(context.Background(), cfg.ProxyHealthPath, time.Duration(cfg.ProxyHealthCheckSeconds)*time.Second)
+
+	return gateway
+}
+
+// StartGRPC serves the gRPC server on the port until it is stopped.
+func StartGRPC(port string, grpcServer *grpc.Server) {
+	listener, err := net.Listen("tcp", ":"+port)
+	if err != nil {
+		log.Fatal("Error listening on gRPC port:", err)
+	}
+
+	log.Println("Starting gRPC server on port", port)
+	if err := grpcServer.Serve(listener); err != nil {
+		log.Fatal("Error starting gRPC server:", err)
+	}
+}
+
+// WaitForGRPCShutdown stops the gRPC server on SIGINT or SIGTERM, letting the
+// calls in flight finish for up to five seconds.
+func WaitForGRPCShutdown(grpcServer *grpc.Server) {
+	signalChan := make(chan os.Signal, 1)
+	signal.Notify(signalChan, os.Interrupt, syscall.SIGTERM)
+
+	<-signalChan
+
+	stopped := make(chan struct{})
+	go func() {
+		grpcServer.GracefulStop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		grpcServer.Stop()
+	}
+
+	log.Println("Servidor gRPC encerrado")
+}
+
 func WaitForShutdown(server *http.Server) {
 	signalChan := make(chan os.Signal, 1)
 	signal.Notify(signalChan, os.Interrupt, syscall.SIGTERM)
@@ -0,0 +1,170 @@
+// Package stream limits what a client sends over a long-lived connection,
+// such as a WebSocket, where one request carries many messages.
+package stream
+
+import (
+	"context"
+	"errors"
+	"net"
+
+	limiter "github.com/jpodlasnisky/ratelimiter/ratelimiter"
+)
+
+// ErrLimitExceeded is returned in Disconnect mode when a message goes over the limit.
+var ErrLimitExceeded = errors.New("stream rate limit exceeded")
+
+// Unit is what a hit of the stream counts.
+type Unit int
+
+const (
+	PerMessage Unit = iota
+	PerByte
+)
+
+// Mode decides what happens to a message over the limit.
+type Mode int
+
+const (
+	// Throttle waits until the window has room for the message.
+	Throttle Mode = iota
+
+	// Disconnect rejects the message; a wrapped connection is closed.
+	Disconnect
+)
+
+// Limiter counts the messages or bytes of one connection against the limit of
+// its key.
+type Limiter struct {
+	rateLimiter *limiter.RateLimiter
+	key         string
+	isToken     bool
+	limit       int64
+	unit        Unit
+	mode        Mode
+}
+
+type Option func(*Limiter)
+
+func WithUnit(unit Unit) Option {
+	return func(s *Limiter) {
+		s.unit = unit
+	}
+}
+
+func WithMode(mode Mode) Option {
+	return func(s *Limiter) {
+		s.mode = mode
+	}
+}
+
+// WithLimit replaces the limit of the key, e.g. with a number of bytes per
+// window when counting PerByte.
+func WithLimit(limit int64) Option {
+	return func(s *Limiter) {
+		s.limit = limit
+	}
+}
+
+// New builds the limiter of a connection opened by key, the token or IP
+// checked by the middleware. Unless WithLimit is given, a token uses its policy
+// and any other key the IP limit.
+func New(ctx context.Context, rateLimiter *limiter.RateLimiter, key string, opts ...Option) (*Limiter, error) {
+	s := &Limiter{rateLimiter: rateLimiter, key: key, isToken: rateLimiter.TokenExists(key), limit: -1}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	if s.limit >= 0 {
+		return s, nil
+	}
+
+	if s.isToken {
+		limit, err := rateLimiter.PolicyLimit(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		s.limit = limit
+		return s, nil
+	}
+
+	s.limit = rateLimiter.IPLimit()
+	return s, nil
+}
+
+// Message counts a message of size bytes. In Throttle mode it waits for room
+// in the window; in Disconnect mode it returns ErrLimitExceeded right away.
+// The message is a single weighted hit, whatever its size.
+func (s *Limiter) Message(ctx context.Context, size int) error {
+	cost := int64(1)
+	if s.unit == PerByte {
+		cost = int64(size)
+	}
+	if cost <= 0 {
+		return nil
+	}
+
+	// O token é conferido a cada mensagem: a conexão pode durar além da sua
+	// validade ou da rotação
+	key := limiter.KindStream.Key(s.key)
+	if s.isToken {
+		var err error
+		if key, err = s.rateLimiter.TokenKey(limiter.KindStream, s.key); err != nil {
+			return err
+		}
+	}
+
+	if s.mode == Disconnect {
+		allowed, err := s.rateLimiter.AllowWeighted(ctx, key, s.limit, cost)
+		if err != nil {
+			return err
+		}
+		if !allowed {
+			return ErrLimitExceeded
+		}
+		return nil
+	}
+
+	// Uma mensagem maior que o limite é liberada em partes, uma janela por vez.
+	for cost > 0 {
+		chunk := cost
+		if chunk > s.limit {
+			chunk = s.limit
+		}
+		if chunk <= 0 {
+			return ErrLimitExceeded
+		}
+		if err := s.rateLimiter.WaitWeighted(ctx, key, s.limit, chunk); err != nil {
+			return err
+		}
+		cost -= chunk
+	}
+	return nil
+}
+
+// Conn counts every Read of the wrapped connection as a message of the bytes
+// read. For framed protocols such as WebSocket, call Message from the read
+// loop instead, once per frame.
+type Conn struct {
+	net.Conn
+	ctx     context.Context
+	limiter *Limiter
+}
+
+// WrapConn wraps a hijacked connection. The context bounds the waits in
+// Throttle mode and should be done when the connection ends.
+func (s *Limiter) WrapConn(ctx context.Context, conn net.Conn) *Conn {
+	return &Conn{Conn: conn, ctx: ctx, limiter: s}
+}
+
+func (c *Conn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n <= 0 {
+		return n, err
+	}
+
+	if limitErr := c.limiter.Message(c.ctx, n); limitErr != nil {
+		c.Conn.Close()
+		return 0, limitErr
+	}
+	return n, err
+}
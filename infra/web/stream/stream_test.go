@@ -0,0 +1,177 @@
+package stream
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/jpodlasnisky/ratelimiter/infra/database"
+	"github.com/jpodlasnisky/ratelimiter/infra/database/contract_db"
+	limiter "github.com/jpodlasnisky/ratelimiter/ratelimiter"
+	"github.com/stretchr/testify/assert"
+)
+
+func setupLimiter(t *testing.T, window time.Duration, opts ...limiter.Option) *limiter.RateLimiter {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+
+	opts = append([]limiter.Option{
+		limiter.WithPolicies(map[string]int64{"TOKEN_1": 3}),
+		limiter.WithIPLimit(2),
+		limiter.WithWindow(window),
+	}, opts...)
+	rateLimiter := limiter.New(contract_db.NewDatastoreStore(database.NewRedisDataLimiter(client)), opts...)
+	if err := rateLimiter.RegisterPersonalizedTokens(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return rateLimiter
+}
+
+// countingDatastore counts the ZADD calls, one round trip each in Redis.
+type countingDatastore struct {
+	*database.RedisDataLimiter
+	zadds int
+}
+
+func (d *countingDatastore) ZAdd(ctx context.Context, key string, members ...*redis.Z) (int64, error) {
+	d.zadds++
+	return d.RedisDataLimiter.ZAdd(ctx, key, members...)
+}
+
+func TestMessage_PerByteLargeMessage(t *testing.T) {
+	ctx := context.Background()
+	mr := miniredis.RunT(t)
+	datastore := &countingDatastore{RedisDataLimiter: database.NewRedisDataLimiter(redis.NewClient(&redis.Options{Addr: mr.Addr()}))}
+	rateLimiter := limiter.New(contract_db.NewDatastoreStore(datastore), limiter.WithWindow(time.Minute))
+
+	s, err := New(ctx, rateLimiter, "10.0.0.1", WithUnit(PerByte), WithMode(Disconnect), WithLimit(1<<20))
+	assert.NoError(t, err)
+
+	assert.NoError(t, s.Message(ctx, 1<<20))
+	assert.Equal(t, 1, datastore.zadds, "the bytes of a message must be recorded in one ZADD")
+
+	keys := mr.Keys()
+	if assert.Len(t, keys, 1) {
+		members, err := mr.ZMembers(keys[0])
+		assert.NoError(t, err)
+		assert.Len(t, members, 1, "the bytes of a message must be a single member")
+	}
+	assert.ErrorIs(t, s.Message(ctx, 1), ErrLimitExceeded, "the single member must weigh the whole message")
+}
+
+func TestNew_ResolvesLimit(t *testing.T) {
+	ctx := context.Background()
+	rateLimiter := setupLimiter(t, time.Minute)
+
+	s, err := New(ctx, rateLimiter, "TOKEN_1")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), s.limit)
+
+	s, err = New(ctx, rateLimiter, "10.0.0.1")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), s.limit)
+
+	s, err = New(ctx, rateLimiter, "10.0.0.1", WithLimit(1024))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1024), s.limit)
+}
+
+func TestMessage_Disconnect(t *testing.T) {
+	ctx := context.Background()
+	rateLimiter := setupLimiter(t, time.Minute)
+
+	s, err := New(ctx, rateLimiter, "10.0.0.1", WithMode(Disconnect))
+	assert.NoError(t, err)
+
+	assert.NoError(t, s.Message(ctx, 100))
+	assert.NoError(t, s.Message(ctx, 100))
+	assert.ErrorIs(t, s.Message(ctx, 100), ErrLimitExceeded)
+
+	allowed, err := rateLimiter.Allow(ctx, "10.0.0.1")
+	assert.NoError(t, err)
+	assert.True(t, allowed, "messages must not use up the requests of the key")
+}
+
+func TestMessage_PerByte(t *testing.T) {
+	ctx := context.Background()
+	rateLimiter := setupLimiter(t, time.Minute)
+
+	s, err := New(ctx, rateLimiter, "10.0.0.1", WithMode(Disconnect), WithUnit(PerByte), WithLimit(10))
+	assert.NoError(t, err)
+
+	assert.NoError(t, s.Message(ctx, 6))
+	assert.ErrorIs(t, s.Message(ctx, 6), ErrLimitExceeded)
+	assert.NoError(t, s.Message(ctx, 4))
+}
+
+func TestMessage_Throttle(t *testing.T) {
+	ctx := context.Background()
+	rateLimiter := setupLimiter(t, 50*time.Millisecond)
+
+	s, err := New(ctx, rateLimiter, "10.0.0.1", WithLimit(1))
+	assert.NoError(t, err)
+
+	start := time.Now()
+	assert.NoError(t, s.Message(ctx, 1))
+	assert.NoError(t, s.Message(ctx, 1))
+	assert.GreaterOrEqual(t, time.Since(start), 40*time.Millisecond)
+}
+
+func TestMessage_ThrottleContextDone(t *testing.T) {
+	rateLimiter := setupLimiter(t, time.Minute)
+
+	s, err := New(context.Background(), rateLimiter, "10.0.0.1", WithLimit(1))
+	assert.NoError(t, err)
+	assert.NoError(t, s.Message(context.Background(), 1))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.Error(t, s.Message(ctx, 1))
+}
+
+func TestConn_DisconnectsOverLimit(t *testing.T) {
+	ctx := context.Background()
+	rateLimiter := setupLimiter(t, time.Minute)
+
+	s, err := New(ctx, rateLimiter, "10.0.0.1", WithMode(Disconnect), WithUnit(PerByte), WithLimit(5))
+	assert.NoError(t, err)
+
+	server, client := net.Pipe()
+	defer client.Close()
+	conn := s.WrapConn(ctx, server)
+
+	go func() {
+		client.Write([]byte("abcd"))
+		client.Write([]byte("efgh"))
+	}()
+
+	buf := make([]byte, 16)
+	n, err := conn.Read(buf)
+	assert.NoError(t, err)
+	assert.Equal(t, "abcd", string(buf[:n]))
+
+	_, err = conn.Read(buf)
+	assert.ErrorIs(t, err, ErrLimitExceeded)
+
+	_, err = server.Read(buf)
+	assert.Error(t, err, "the connection must be closed")
+}
+
+func TestMessage_TokenExpiresDuringStream(t *testing.T) {
+	ctx := context.Background()
+	clock := limiter.NewFakeClock(time.Now())
+	rateLimiter := setupLimiter(t, time.Minute,
+		limiter.WithClock(clock),
+		limiter.WithTokenSchedules(map[string]limiter.TokenSchedule{"TOKEN_1": {ExpiresAt: clock.Now().Add(time.Hour)}}),
+	)
+
+	s, err := New(ctx, rateLimiter, "TOKEN_1", WithMode(Disconnect))
+	assert.NoError(t, err)
+	assert.NoError(t, s.Message(ctx, 1))
+
+	clock.Advance(2 * time.Hour)
+	assert.ErrorIs(t, s.Message(ctx, 1), limiter.ErrTokenExpired, "every message must check the token")
+}
@@ -0,0 +1,154 @@
+// Package transport limits outbound HTTP calls with the shared limiter, so
+// every instance draws from the same vendor quota.
+package transport
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	limiter "github.com/jpodlasnisky/ratelimiter/ratelimiter"
+)
+
+// ErrLimited is returned by a fail-fast Transport when the key has no room left.
+var ErrLimited = errors.New("outbound request rate limited")
+
+// maxRetryAfter caps the block asked by a vendor's Retry-After, so a wrong or
+// hostile header can't block the key for good.
+const maxRetryAfter = 24 * time.Hour
+
+// Transport is an http.RoundTripper that runs each request through the
+// limiter before sending it. The key of a request is its host by default, and
+// the vendor quota is set with WithLimit.
+type Transport struct {
+	base        http.RoundTripper
+	rateLimiter *limiter.RateLimiter
+	keyFunc     func(*http.Request) string
+	limit       int64
+	failFast    bool
+}
+
+type Option func(*Transport)
+
+// WithBase sets the RoundTripper that sends the requests. The default is
+// http.DefaultTransport.
+func WithBase(base http.RoundTripper) Option {
+	return func(t *Transport) {
+		t.base = base
+	}
+}
+
+// WithKeyFunc sets how the key of a request is built.
+func WithKeyFunc(keyFunc func(*http.Request) string) Option {
+	return func(t *Transport) {
+		t.keyFunc = keyFunc
+	}
+}
+
+// WithLimit sets the number of requests per window of each key, e.g. the
+// quota of the vendor. The default is the IP limit of the limiter.
+func WithLimit(limit int64) Option {
+	return func(t *Transport) {
+		t.limit = limit
+	}
+}
+
+// WithFailFast makes the Transport return ErrLimited instead of waiting for
+// room in the window.
+func WithFailFast() Option {
+	return func(t *Transport) {
+		t.failFast = true
+	}
+}
+
+func New(rateLimiter *limiter.RateLimiter, opts ...Option) *Transport {
+	t := &Transport{
+		base:        http.DefaultTransport,
+		rateLimiter: rateLimiter,
+		keyFunc:     hostKey,
+		limit:       rateLimiter.IPLimit(),
+	}
+	for _, opt := range opts {
+		opt(t)
+	}
+	return t
+}
+
+func hostKey(req *http.Request) string {
+	return req.URL.Host
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	key := limiter.KindOutbound.Key(t.keyFunc(req))
+
+	if t.failFast {
+		allowed, err := t.rateLimiter.AllowNWithLimit(ctx, key, t.limit, 1)
+		if err != nil {
+			closeBody(req)
+			return nil, err
+		}
+		if !allowed {
+			closeBody(req)
+			return nil, ErrLimited
+		}
+	} else if err := t.rateLimiter.WaitNWithLimit(ctx, key, t.limit, 1); err != nil {
+		closeBody(req)
+		return nil, err
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil || resp.StatusCode != http.StatusTooManyRequests {
+		return resp, err
+	}
+
+	// O fornecedor pediu para esperar: bloqueia a chave para todas as instâncias.
+	backoff, ok := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+	if !ok {
+		return resp, nil
+	}
+	if err := t.rateLimiter.BlockKeyFor(ctx, key, backoff); err != nil {
+		log.Printf("Erro ao bloquear a chave %s após 429: %v", key, err)
+	}
+	return resp, nil
+}
+
+// closeBody closes the body of a request that is not sent, as a RoundTripper
+// must do even when it fails.
+func closeBody(req *http.Request) {
+	if req.Body != nil {
+		req.Body.Close()
+	}
+}
+
+// parseRetryAfter reads the header as seconds or as an HTTP date, capped at
+// maxRetryAfter.
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+
+	seconds, err := strconv.ParseInt(value, 10, 64)
+	if errors.Is(err, strconv.ErrRange) && !strings.HasPrefix(value, "-") {
+		return maxRetryAfter, true
+	}
+	if err == nil {
+		if seconds <= 0 {
+			return 0, false
+		}
+		if seconds > int64(maxRetryAfter/time.Second) {
+			return maxRetryAfter, true
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	if date, err := http.ParseTime(value); err == nil {
+		if backoff := date.Sub(now); backoff > 0 {
+			return min(backoff, maxRetryAfter), true
+		}
+	}
+	return 0, false
+}
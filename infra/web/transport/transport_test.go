@@ -0,0 +1,171 @@
+package transport
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/jpodlasnisky/ratelimiter/infra/database"
+	"github.com/jpodlasnisky/ratelimiter/infra/database/contract_db"
+	limiter "github.com/jpodlasnisky/ratelimiter/ratelimiter"
+	"github.com/stretchr/testify/assert"
+)
+
+func setupVendor(t *testing.T, handler http.HandlerFunc) (*httptest.Server, *atomic.Int64) {
+	var calls atomic.Int64
+	vendor := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		handler(w, r)
+	}))
+	t.Cleanup(vendor.Close)
+	return vendor, &calls
+}
+
+func setupLimiter(t *testing.T, opts ...limiter.Option) *limiter.RateLimiter {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+
+	return limiter.New(contract_db.NewDatastoreStore(database.NewRedisDataLimiter(client)), opts...)
+}
+
+func hostOf(t *testing.T, rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u.Host
+}
+
+func TestTransport_FailFast(t *testing.T) {
+	vendor, calls := setupVendor(t, func(w http.ResponseWriter, r *http.Request) {})
+	clock := limiter.NewFakeClock(time.Now())
+	rateLimiter := setupLimiter(t, limiter.WithClock(clock), limiter.WithWindow(time.Minute))
+	client := &http.Client{Transport: New(rateLimiter, WithLimit(2), WithFailFast())}
+
+	for i := 0; i < 2; i++ {
+		resp, err := client.Get(vendor.URL)
+		assert.NoError(t, err)
+		resp.Body.Close()
+	}
+
+	_, err := client.Get(vendor.URL)
+	assert.ErrorIs(t, err, ErrLimited)
+	assert.Equal(t, int64(2), calls.Load())
+}
+
+// trackedBody records whether the request body was closed.
+type trackedBody struct {
+	io.Reader
+	closed bool
+}
+
+func (b *trackedBody) Close() error {
+	b.closed = true
+	return nil
+}
+
+func TestTransport_ClosesBodyWhenLimited(t *testing.T) {
+	vendor, _ := setupVendor(t, func(w http.ResponseWriter, r *http.Request) {})
+	rateLimiter := setupLimiter(t, limiter.WithWindow(time.Minute))
+	transport := New(rateLimiter, WithLimit(1), WithFailFast())
+
+	req, err := http.NewRequest(http.MethodPost, vendor.URL, strings.NewReader("first"))
+	assert.NoError(t, err)
+	resp, err := transport.RoundTrip(req)
+	assert.NoError(t, err)
+	resp.Body.Close()
+
+	body := &trackedBody{Reader: strings.NewReader("second")}
+	req, err = http.NewRequest(http.MethodPost, vendor.URL, body)
+	assert.NoError(t, err)
+
+	_, err = transport.RoundTrip(req)
+	assert.ErrorIs(t, err, ErrLimited)
+	assert.True(t, body.closed, "a RoundTripper must close the body of a request it doesn't send")
+}
+
+func TestTransport_RetryAfterBlocksKey(t *testing.T) {
+	vendor, calls := setupVendor(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "30")
+		w.WriteHeader(http.StatusTooManyRequests)
+	})
+	host := hostOf(t, vendor.URL)
+	clock := limiter.NewFakeClock(time.Now())
+	rateLimiter := setupLimiter(t, limiter.WithClock(clock))
+	client := &http.Client{Transport: New(rateLimiter, WithLimit(10), WithFailFast())}
+
+	resp, err := client.Get(vendor.URL)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+
+	_, err = client.Get(vendor.URL)
+	assert.ErrorIs(t, err, ErrLimited)
+	assert.Equal(t, int64(1), calls.Load())
+
+	blocked, err := rateLimiter.IsKeyBlocked(context.Background(), limiter.KindOutbound.Key(host))
+	assert.NoError(t, err)
+	assert.True(t, blocked, "the 429 must block the outbound key")
+
+	blocked, err = rateLimiter.IsKeyBlocked(context.Background(), host)
+	assert.NoError(t, err)
+	assert.False(t, blocked, "inbound keys named after the host must not be touched")
+}
+
+func TestTransport_Wait(t *testing.T) {
+	vendor, calls := setupVendor(t, func(w http.ResponseWriter, r *http.Request) {})
+	rateLimiter := setupLimiter(t, limiter.WithWindow(50*time.Millisecond))
+	client := &http.Client{Transport: New(rateLimiter, WithLimit(1))}
+
+	start := time.Now()
+	for i := 0; i < 2; i++ {
+		resp, err := client.Get(vendor.URL)
+		assert.NoError(t, err)
+		resp.Body.Close()
+	}
+
+	assert.GreaterOrEqual(t, time.Since(start), 40*time.Millisecond)
+	assert.Equal(t, int64(2), calls.Load())
+}
+
+func TestTransport_QuotaIsNotAToken(t *testing.T) {
+	vendor, _ := setupVendor(t, func(w http.ResponseWriter, r *http.Request) {})
+	rateLimiter := setupLimiter(t)
+	New(rateLimiter, WithLimit(5))
+
+	assert.False(t, rateLimiter.TokenExists(hostOf(t, vendor.URL)), "the vendor host must not become a valid API key")
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		value    string
+		expected time.Duration
+		ok       bool
+	}{
+		{"120", 2 * time.Minute, true},
+		{now.Add(90 * time.Second).Format(http.TimeFormat), 90 * time.Second, true},
+		{now.Add(-time.Minute).Format(http.TimeFormat), 0, false},
+		{"0", 0, false},
+		{"", 0, false},
+		{"soon", 0, false},
+		{"99999999999999999999", maxRetryAfter, true},
+		{"9223372036", maxRetryAfter, true},
+		{now.AddDate(5, 0, 0).Format(http.TimeFormat), maxRetryAfter, true},
+	}
+
+	for _, tt := range tests {
+		backoff, ok := parseRetryAfter(tt.value, now)
+		assert.Equal(t, tt.ok, ok, tt.value)
+		assert.Equal(t, tt.expected, backoff, tt.value)
+	}
+}
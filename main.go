@@ -6,8 +6,10 @@ import (
 	"net/http"
 
 	"github.com/jpodlasnisky/ratelimiter/config"
+	"github.com/jpodlasnisky/ratelimiter/infra/grpc/rls"
 	"github.com/jpodlasnisky/ratelimiter/infra/web/middleware"
 	"github.com/jpodlasnisky/ratelimiter/infra/web/server"
+	"google.golang.org/grpc"
 )
 
 func main() {
@@ -18,14 +20,46 @@ func main() {
 
 	rateLimiter := server.SetupRateLimiter(cfg)
 
+	if cfg.ServerMode == server.ModeEnvoy {
+		grpcServer := grpc.NewServer()
+		rls.Register(grpcServer, rateLimiter)
+
+		go server.StartGRPC(cfg.GRPCPort, grpcServer)
+
+		server.WaitForGRPCShutdown(grpcServer)
+		return
+	}
+
+	middlewareOptions := server.SetupMiddlewareOptions(cfg)
+
 	mux := http.NewServeMux()
-	server.SetupRoutes(mux)
 
-	rateLimitMiddleware := middleware.RateLimitMiddleware(mux, rateLimiter)
+	var appHandler http.Handler
+	switch cfg.ServerMode {
+	case server.ModeDecision:
+		server.SetupDecisionRoutes(mux, rateLimiter)
+		appHandler = mux
+	case server.ModeProxy:
+		appHandler = middleware.RateLimitMiddleware(server.SetupProxy(cfg), rateLimiter, middlewareOptions...)
+	case server.ModeMiddleware:
+		server.SetupRoutes(mux)
+		appHandler = middleware.RateLimitMiddleware(mux, rateLimiter, middlewareOptions...)
+	default:
+		log.Fatal("Modo de servidor inválido: ", cfg.ServerMode)
+	}
 
-	loggingMiddleware := middleware.LoggingMiddleware(rateLimitMiddleware)
+	loggingMiddleware := middleware.LoggingMiddleware(appHandler)
 
 	srv := server.New(cfg.WebPort, loggingMiddleware)
+	if cfg.ServerMode == server.ModeProxy {
+		// Sem limite de escrita, para não cortar respostas em streaming dos upstreams.
+		srv.WriteTimeout = 0
+	}
+	if cfg.TLSCertFile != "" {
+		if err := srv.EnableTLS(cfg.TLSCertFile, cfg.TLSKeyFile, cfg.TLSClientCAFile); err != nil {
+			log.Fatal("Erro ao configurar o TLS:", err)
+		}
+	}
 
 	go func() {
 		log.Println("Servidor HTTP iniciado na porta:", cfg.WebPort)
@@ -0,0 +1,283 @@
+package ratelimiter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/jpodlasnisky/ratelimiter/infra/database/contract_db"
+)
+
+// InfDuration is the delay of a reservation that can't be honoured.
+const InfDuration = time.Duration(math.MaxInt64)
+
+// ErrNegativeHits is returned when a negative number of hits is asked.
+var ErrNegativeHits = errors.New("ratelimiter: negative number of hits")
+
+// Reservation holds hits booked in the shared window for now or for later,
+// like rate.Reservation does for a local token bucket.
+type Reservation struct {
+	ok        bool
+	limiter   *RateLimiter
+	keys      []string
+	hitIDs    []string
+	timeToAct time.Time
+}
+
+// OK reports whether the hits were booked. It is false when more hits were
+// asked than the limit allows or when the wait would be longer than allowed.
+func (r *Reservation) OK() bool {
+	return r.ok
+}
+
+// Delay is how long the caller has to wait before acting on the reservation.
+func (r *Reservation) Delay() time.Duration {
+	return r.DelayFrom(r.limiter.clock.Now())
+}
+
+func (r *Reservation) DelayFrom(now time.Time) time.Duration {
+	if !r.ok {
+		return InfDuration
+	}
+	if delay := r.timeToAct.Sub(now); delay > 0 {
+		return delay
+	}
+	return 0
+}
+
+// Cancel gives the booked hits back to the window, so other callers can use them.
+func (r *Reservation) Cancel(ctx context.Context) error {
+	if !r.ok {
+		return nil
+	}
+
+	for _, key := range r.keys {
+		if err := r.limiter.Store.RemoveHits(ctx, key, r.hitIDs); err != nil {
+			return err
+		}
+	}
+	r.hitIDs = nil
+	return nil
+}
+
+// Allow reports whether one request for key may happen now. Unlike
+// IsRateLimitExceeded it never blocks the key, so it suits callers that
+// throttle themselves.
+func (l *RateLimiter) Allow(ctx context.Context, key string) (bool, error) {
+	return l.AllowN(ctx, key, 1)
+}
+
+func (l *RateLimiter) AllowN(ctx context.Context, key string, n int) (bool, error) {
+	limit, err := l.limitOf(ctx, key)
+	if err != nil {
+		err = l.handleStoreError(key, err)
+		return err == nil, err
+	}
+	return l.AllowNWithLimit(ctx, key, limit, n)
+}
+
+// AllowNWithLimit is AllowN with the limit given by the caller instead of the
+// policy of the key.
+func (l *RateLimiter) AllowNWithLimit(ctx context.Context, key string, limit int64, n int) (bool, error) {
+	r, err := l.reserveN(ctx, key, limit, n, 0)
+	if err != nil {
+		err = l.handleStoreError(key, err)
+		return err == nil, err
+	}
+
+	l.metrics.RecordDecision(key, l.TokenExists(key), !r.OK())
+	return r.OK(), nil
+}
+
+// Reserve books one hit for key, now or as soon as the window has room, and
+// tells how long to wait for it.
+func (l *RateLimiter) Reserve(ctx context.Context, key string) (*Reservation, error) {
+	return l.ReserveN(ctx, key, 1)
+}
+
+func (l *RateLimiter) ReserveN(ctx context.Context, key string, n int) (*Reservation, error) {
+	limit, err := l.limitOf(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return l.reserveN(ctx, key, limit, n, InfDuration)
+}
+
+// Wait blocks until one request for key is allowed or the context is done.
+func (l *RateLimiter) Wait(ctx context.Context, key string) error {
+	return l.WaitN(ctx, key, 1)
+}
+
+func (l *RateLimiter) WaitN(ctx context.Context, key string, n int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	limit, err := l.limitOf(ctx, key)
+	if err != nil {
+		return l.handleStoreError(key, err)
+	}
+	return l.WaitNWithLimit(ctx, key, limit, n)
+}
+
+// WaitNWithLimit is WaitN with the limit given by the caller instead of the
+// policy of the key.
+func (l *RateLimiter) WaitNWithLimit(ctx context.Context, key string, limit int64, n int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	maxWait := InfDuration
+	if deadline, ok := ctx.Deadline(); ok {
+		maxWait = deadline.Sub(l.clock.Now())
+	}
+
+	r, err := l.reserveN(ctx, key, limit, n, maxWait)
+	if err != nil {
+		return l.handleStoreError(key, err)
+	}
+	if !r.OK() {
+		if r.timeToAct.IsZero() {
+			return fmt.Errorf("ratelimiter: Wait(n=%d) exceeds the limit of key %s", n, l.logKey(key))
+		}
+		return fmt.Errorf("ratelimiter: Wait(n=%d) would exceed the context deadline", n)
+	}
+	return l.waitReservation(ctx, key, r)
+}
+
+// waitReservation waits until the reservation can be acted on, and cancels it
+// when the context is done first.
+func (l *RateLimiter) waitReservation(ctx context.Context, key string, r *Reservation) error {
+	delay := r.DelayFrom(l.clock.Now())
+	if delay == 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		if err := r.Cancel(context.Background()); err != nil {
+			l.logger.Printf("Error cancelling reservation for key %s: %v", l.logKey(key), err)
+		}
+		return ctx.Err()
+	}
+}
+
+// reserveN books n hits when they can happen within maxWait. Otherwise it
+// returns a reservation that is not OK, with timeToAct set to when the hits
+// would be possible, or zero when n is above the limit. The hits of a token
+// are booked in its project and organization too, and in the token it
+// replaces during a rotation.
+//
+// Like Acquire, the hits are recorded before the window is counted and given
+// back when they don't fit, so concurrent callers can't all take the last
+// hits of the window.
+func (l *RateLimiter) reserveN(ctx context.Context, key string, limit int64, n int, maxWait time.Duration) (*Reservation, error) {
+	if n < 0 {
+		return nil, ErrNegativeHits
+	}
+
+	isToken := l.TokenExists(key)
+	if isToken {
+		bucket, err := l.tokenBucket(key)
+		if err != nil {
+			return nil, err
+		}
+		key = bucket
+	}
+
+	reservation := &Reservation{limiter: l}
+
+	levels := l.levelsFor(key, limit, isToken)
+	for _, lvl := range levels {
+		if int64(n) > lvl.limit {
+			return reservation, nil
+		}
+	}
+
+	now := l.clock.Now()
+	actAt := now
+
+	reservation.hitIDs = l.newHitIDs(now, n)
+	reservation.ok = true
+	for _, lvl := range levels {
+		storeKey := l.storeKey(lvl.key)
+		if err := l.Store.RecordHits(ctx, storeKey, reservation.hitIDs, l.hitExpiry(now), now); err != nil {
+			reservation.Cancel(ctx)
+			return nil, err
+		}
+		reservation.keys = append(reservation.keys, storeKey)
+	}
+
+	for _, lvl := range levels {
+		storeKey := l.storeKey(lvl.key)
+
+		blockedUntil, isBlocked, err := l.blockedUntil(ctx, lvl.key)
+		if err != nil {
+			reservation.Cancel(ctx)
+			return nil, err
+		}
+		if isBlocked && blockedUntil.After(actAt) {
+			actAt = blockedUntil
+		}
+
+		count, err := l.Store.CountInWindow(ctx, storeKey, now)
+		if err != nil {
+			reservation.Cancel(ctx)
+			return nil, err
+		}
+
+		// The n hits, already in the window, fit once count-limit of the other
+		// hits in it, booked ones included, have left it. The window is ordered
+		// by expiry, so the hits from index over-1 on may be ours and then the
+		// one sought is n places later.
+		if over := count - lvl.limit; over > 0 {
+			expiry, err := l.Store.HitExpiryAt(ctx, storeKey, over-1)
+			if err == nil && !expiry.Before(l.hitExpiry(now)) {
+				expiry, err = l.Store.HitExpiryAt(ctx, storeKey, over-1+int64(n))
+			}
+			if err != nil && !errors.Is(err, contract_db.ErrNotFound) {
+				reservation.Cancel(ctx)
+				return nil, err
+			}
+			if expiry.After(actAt) {
+				actAt = expiry
+			}
+		}
+	}
+
+	reservation.timeToAct = actAt
+	if actAt.Sub(now) > maxWait {
+		if err := reservation.Cancel(ctx); err != nil {
+			return nil, err
+		}
+		reservation.ok = false
+		return reservation, nil
+	}
+
+	if actAt.After(now) {
+		// The hits are booked for later: they count until a window after
+		// actAt. The new ones are recorded before the old ones are removed,
+		// so the window never looks emptier than it is.
+		booked := l.newHitIDs(now, n)
+		for _, storeKey := range reservation.keys {
+			if err := l.Store.RecordHits(ctx, storeKey, booked, l.hitExpiry(actAt), now); err != nil {
+				reservation.Cancel(ctx)
+				return nil, err
+			}
+			if err := l.Store.RemoveHits(ctx, storeKey, reservation.hitIDs); err != nil {
+				reservation.Cancel(ctx)
+				return nil, err
+			}
+		}
+		reservation.hitIDs = booked
+	}
+
+	return reservation, nil
+}
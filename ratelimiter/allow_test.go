@@ -0,0 +1,262 @@
+package ratelimiter
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newFakeLimiter(limit int64, window time.Duration) (*RateLimiter, *FakeClock) {
+	clock := NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	limiter := New(newMemoryStore(clock),
+		WithClock(clock),
+		WithIPLimit(limit),
+		WithWindow(window),
+		WithBlockDuration(time.Minute),
+	)
+	return limiter, clock
+}
+
+func TestAllow(t *testing.T) {
+	ctx := context.Background()
+	limiter, clock := newFakeLimiter(2, time.Second)
+
+	steps := []struct {
+		advance time.Duration
+		allowed bool
+	}{
+		{0, true},
+		{0, true},
+		{0, false},
+		{500 * time.Millisecond, false},
+		{500 * time.Millisecond, true},
+	}
+
+	for i, step := range steps {
+		clock.Advance(step.advance)
+		allowed, err := limiter.Allow(ctx, "worker")
+		assert.NoError(t, err)
+		assert.Equal(t, step.allowed, allowed, "step %d", i)
+	}
+
+	blocked, err := limiter.IsKeyBlocked(ctx, "worker")
+	assert.NoError(t, err)
+	assert.False(t, blocked, "Allow must not block the key")
+}
+
+func TestAllowN(t *testing.T) {
+	ctx := context.Background()
+	limiter, _ := newFakeLimiter(5, time.Second)
+
+	allowed, err := limiter.AllowN(ctx, "worker", 4)
+	assert.NoError(t, err)
+	assert.True(t, allowed)
+
+	allowed, err = limiter.AllowN(ctx, "worker", 2)
+	assert.NoError(t, err)
+	assert.False(t, allowed)
+
+	allowed, err = limiter.AllowN(ctx, "worker", 1)
+	assert.NoError(t, err)
+	assert.True(t, allowed)
+
+	allowed, err = limiter.AllowN(ctx, "other", 6)
+	assert.NoError(t, err)
+	assert.False(t, allowed, "more hits than the limit are never allowed")
+}
+
+func TestAllow_BlockedKey(t *testing.T) {
+	ctx := context.Background()
+	limiter, _ := newFakeLimiter(5, time.Second)
+
+	assert.NoError(t, limiter.BlockKey(ctx, "worker"))
+
+	allowed, err := limiter.Allow(ctx, "worker")
+	assert.NoError(t, err)
+	assert.False(t, allowed)
+}
+
+func TestReserve_QueuesAfterWindow(t *testing.T) {
+	ctx := context.Background()
+	limiter, clock := newFakeLimiter(2, time.Second)
+
+	var delays []time.Duration
+	for i := 0; i < 5; i++ {
+		r, err := limiter.Reserve(ctx, "worker")
+		assert.NoError(t, err)
+		assert.True(t, r.OK())
+		delays = append(delays, r.Delay())
+		clock.Advance(100 * time.Millisecond)
+	}
+
+	assert.Equal(t, []time.Duration{
+		0,
+		0,
+		800 * time.Millisecond,
+		800 * time.Millisecond,
+		1600 * time.Millisecond,
+	}, delays)
+}
+
+func TestReserve_Cancel(t *testing.T) {
+	ctx := context.Background()
+	limiter, _ := newFakeLimiter(1, time.Second)
+
+	first, err := limiter.Reserve(ctx, "worker")
+	assert.NoError(t, err)
+	assert.Equal(t, time.Duration(0), first.Delay())
+
+	second, err := limiter.Reserve(ctx, "worker")
+	assert.NoError(t, err)
+	assert.Equal(t, time.Second, second.Delay())
+
+	assert.NoError(t, second.Cancel(ctx))
+
+	third, err := limiter.Reserve(ctx, "worker")
+	assert.NoError(t, err)
+	assert.Equal(t, time.Second, third.Delay(), "the cancelled hit must not delay the next reservation")
+}
+
+func TestReserve_BlockedKey(t *testing.T) {
+	ctx := context.Background()
+	limiter, _ := newFakeLimiter(1, time.Second)
+
+	assert.NoError(t, limiter.BlockKey(ctx, "worker"))
+
+	r, err := limiter.Reserve(ctx, "worker")
+	assert.NoError(t, err)
+	assert.Equal(t, time.Minute, r.Delay())
+}
+
+func TestReserveN_AboveLimit(t *testing.T) {
+	limiter, _ := newFakeLimiter(1, time.Second)
+
+	r, err := limiter.ReserveN(context.Background(), "worker", 2)
+	assert.NoError(t, err)
+	assert.False(t, r.OK())
+	assert.Equal(t, InfDuration, r.Delay())
+}
+
+func TestAllowN_NegativeN(t *testing.T) {
+	limiter, _ := newFakeLimiter(1, time.Second)
+
+	limiter.failMode = FailOpen
+
+	_, err := limiter.AllowN(context.Background(), "worker", -1)
+	assert.ErrorIs(t, err, ErrNegativeHits)
+
+	_, err = limiter.ReserveN(context.Background(), "worker", -1)
+	assert.ErrorIs(t, err, ErrNegativeHits)
+}
+
+func TestAllow_Concurrent(t *testing.T) {
+	limiter, _ := newFakeLimiter(5, time.Minute)
+
+	var wg sync.WaitGroup
+	var allowed atomic.Int64
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ok, err := limiter.Allow(context.Background(), "worker")
+			assert.NoError(t, err)
+			if ok {
+				allowed.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.LessOrEqual(t, allowed.Load(), int64(5))
+}
+
+func TestWait(t *testing.T) {
+	ctx := context.Background()
+	limiter := New(newMemoryStore(SystemClock()), WithIPLimit(1), WithWindow(50*time.Millisecond))
+
+	start := time.Now()
+	assert.NoError(t, limiter.Wait(ctx, "worker"))
+	assert.NoError(t, limiter.Wait(ctx, "worker"))
+	assert.GreaterOrEqual(t, time.Since(start), 40*time.Millisecond)
+}
+
+func TestWait_ContextDeadline(t *testing.T) {
+	limiter := New(newMemoryStore(SystemClock()), WithIPLimit(1), WithWindow(time.Minute))
+
+	assert.NoError(t, limiter.Wait(context.Background(), "worker"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	err := limiter.Wait(ctx, "worker")
+	assert.Error(t, err)
+
+	allowedLater, err := limiter.Store.CountInWindow(context.Background(), "worker", time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), allowedLater, "a wait that can't finish must not book a hit")
+}
+
+func TestWait_Cancelled(t *testing.T) {
+	limiter := New(newMemoryStore(SystemClock()), WithIPLimit(1), WithWindow(time.Minute))
+
+	assert.NoError(t, limiter.Wait(context.Background(), "worker"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+
+	assert.ErrorIs(t, limiter.Wait(ctx, "worker"), context.Canceled)
+
+	count, err := limiter.Store.CountInWindow(context.Background(), "worker", time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), count, "a cancelled wait must give its hit back")
+}
+
+// countingStore counts the calls that write hits to the store.
+type countingStore struct {
+	*memoryStore
+	writes int
+}
+
+func (s *countingStore) RecordHit(ctx context.Context, key, hitID string, expiresAt, now time.Time) error {
+	s.writes++
+	return s.memoryStore.RecordHit(ctx, key, hitID, expiresAt, now)
+}
+
+func (s *countingStore) RecordHits(ctx context.Context, key string, hitIDs []string, expiresAt, now time.Time) error {
+	s.writes++
+	return s.memoryStore.RecordHits(ctx, key, hitIDs, expiresAt, now)
+}
+
+func (s *countingStore) RemoveHits(ctx context.Context, key string, hitIDs []string) error {
+	s.writes++
+	return s.memoryStore.RemoveHits(ctx, key, hitIDs)
+}
+
+func TestReserveN_RecordsHitsInOneCall(t *testing.T) {
+	ctx := context.Background()
+	store := &countingStore{memoryStore: newMemoryStore(SystemClock())}
+	limiter := New(store, WithWindow(time.Minute))
+
+	r, err := limiter.reserveN(ctx, "stream", 1<<20, 32*1024, 0)
+	assert.NoError(t, err)
+	assert.True(t, r.OK())
+	assert.Equal(t, 1, store.writes, "a cost of 32768 must be a single write")
+
+	count, err := store.CountInWindow(ctx, "stream", time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, int64(32*1024), count)
+
+	assert.NoError(t, r.Cancel(ctx))
+	assert.Equal(t, 2, store.writes, "cancelling must be a single write too")
+
+	count, err = store.CountInWindow(ctx, "stream", time.Now())
+	assert.NoError(t, err)
+	assert.Zero(t, count)
+}
@@ -0,0 +1,135 @@
+package ratelimiter
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+)
+
+const defaultBlockCacheSize = 10000
+
+// blockCache keeps the blocked keys and when their block ends, so a blocked
+// client can be rejected without asking the Datastore again.
+type blockCache struct {
+	mu       sync.Mutex
+	capacity int
+	entries  map[string]*blockEntry
+	byExpiry blockHeap
+}
+
+type blockEntry struct {
+	key       string
+	expiresAt time.Time
+	index     int
+}
+
+func newBlockCache(capacity int) *blockCache {
+	return &blockCache{
+		capacity: capacity,
+		entries:  make(map[string]*blockEntry),
+	}
+}
+
+func (c *blockCache) add(key string, expiresAt, now time.Time) {
+	if c == nil || c.capacity <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if entry, exists := c.entries[key]; exists {
+		entry.expiresAt = expiresAt
+		heap.Fix(&c.byExpiry, entry.index)
+		return
+	}
+
+	if len(c.entries) >= c.capacity {
+		c.evict(now)
+	}
+	entry := &blockEntry{key: key, expiresAt: expiresAt}
+	heap.Push(&c.byExpiry, entry)
+	c.entries[key] = entry
+}
+
+func (c *blockCache) isBlocked(key string, now time.Time) bool {
+	_, blocked := c.blockedUntil(key, now)
+	return blocked
+}
+
+func (c *blockCache) blockedUntil(key string, now time.Time) (time.Time, bool) {
+	if c == nil {
+		return time.Time{}, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, exists := c.entries[key]
+	if !exists {
+		return time.Time{}, false
+	}
+	if !now.Before(entry.expiresAt) {
+		c.delete(entry)
+		return time.Time{}, false
+	}
+	return entry.expiresAt, true
+}
+
+func (c *blockCache) remove(key string) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if entry, exists := c.entries[key]; exists {
+		c.delete(entry)
+	}
+}
+
+// evict drops the expired entries and, if the cache is still full, the entry
+// whose block ends first. The entries are kept in a heap ordered by expiry, so
+// each one dropped costs O(log n). It must be called with the lock held.
+func (c *blockCache) evict(now time.Time) {
+	for len(c.byExpiry) > 0 && !now.Before(c.byExpiry[0].expiresAt) {
+		c.delete(c.byExpiry[0])
+	}
+
+	if len(c.entries) >= c.capacity && len(c.byExpiry) > 0 {
+		c.delete(c.byExpiry[0])
+	}
+}
+
+// delete must be called with the lock held.
+func (c *blockCache) delete(entry *blockEntry) {
+	heap.Remove(&c.byExpiry, entry.index)
+	delete(c.entries, entry.key)
+}
+
+// blockHeap orders the entries of the cache by when their block ends.
+type blockHeap []*blockEntry
+
+func (h blockHeap) Len() int           { return len(h) }
+func (h blockHeap) Less(i, j int) bool { return h[i].expiresAt.Before(h[j].expiresAt) }
+
+func (h blockHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *blockHeap) Push(x any) {
+	entry := x.(*blockEntry)
+	entry.index = len(*h)
+	*h = append(*h, entry)
+}
+
+func (h *blockHeap) Pop() any {
+	old := *h
+	entry := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return entry
+}
@@ -0,0 +1,75 @@
+package ratelimiter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBlockCache_Expires(t *testing.T) {
+	cache := newBlockCache(10)
+	now := time.Now()
+
+	cache.add("key", now.Add(time.Second), now)
+
+	assert.True(t, cache.isBlocked("key", now))
+	assert.False(t, cache.isBlocked("key", now.Add(time.Second)))
+	assert.Empty(t, cache.entries)
+}
+
+func TestBlockCache_EvictsSoonestWhenFull(t *testing.T) {
+	cache := newBlockCache(2)
+	now := time.Now()
+
+	cache.add("short", now.Add(time.Minute), now)
+	cache.add("long", now.Add(time.Hour), now)
+	cache.add("new", now.Add(30*time.Minute), now)
+
+	assert.Len(t, cache.entries, 2)
+	assert.False(t, cache.isBlocked("short", now))
+	assert.True(t, cache.isBlocked("long", now))
+	assert.True(t, cache.isBlocked("new", now))
+}
+
+func TestBlockCache_Disabled(t *testing.T) {
+	cache := newBlockCache(0)
+
+	now := time.Now()
+	cache.add("key", now.Add(time.Minute), now)
+
+	assert.False(t, cache.isBlocked("key", now))
+}
+
+func TestBlockCache_EvictsExpiredFirst(t *testing.T) {
+	cache := newBlockCache(2)
+	now := time.Now()
+
+	cache.add("expired", now.Add(time.Second), now)
+	cache.add("long", now.Add(time.Hour), now)
+	cache.add("new", now.Add(time.Minute), now.Add(2*time.Second))
+
+	assert.Len(t, cache.entries, 2)
+	assert.Len(t, cache.byExpiry, 2)
+	assert.True(t, cache.isBlocked("long", now))
+	assert.True(t, cache.isBlocked("new", now))
+}
+
+func TestBlockCache_RenewAndRemove(t *testing.T) {
+	cache := newBlockCache(2)
+	now := time.Now()
+
+	cache.add("a", now.Add(time.Minute), now)
+	cache.add("b", now.Add(time.Hour), now)
+	cache.add("a", now.Add(2*time.Hour), now)
+
+	// Renovado, "a" passa a terminar depois de "b" e não é mais o primeiro a sair
+	cache.add("c", now.Add(3*time.Hour), now)
+	assert.False(t, cache.isBlocked("b", now))
+	assert.True(t, cache.isBlocked("a", now))
+
+	cache.remove("a")
+	assert.False(t, cache.isBlocked("a", now))
+	assert.True(t, cache.isBlocked("c", now))
+	assert.Len(t, cache.byExpiry, 1)
+}
@@ -0,0 +1,56 @@
+package ratelimiter
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFakeClock(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := NewFakeClock(start)
+
+	assert.Equal(t, start, clock.Now())
+
+	clock.Advance(time.Minute)
+	assert.Equal(t, start.Add(time.Minute), clock.Now())
+
+	clock.Set(start)
+	assert.Equal(t, start, clock.Now())
+}
+
+func TestRateLimiter_StepsThroughWindowAndBlock(t *testing.T) {
+	ctx := context.Background()
+	clock := NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	limiter := New(newMemoryStore(clock),
+		WithClock(clock),
+		WithWindow(time.Second),
+		WithBlockDuration(5*time.Second),
+		WithIPLimit(2),
+	)
+
+	steps := []struct {
+		name     string
+		advance  time.Duration
+		exceeded bool
+	}{
+		{"first hit", 0, false},
+		{"second hit in the same window", 100 * time.Millisecond, false},
+		{"third hit blocks", 100 * time.Millisecond, true},
+		{"still blocked after the window", 2 * time.Second, true},
+		{"still blocked just before the end", 2800 * time.Millisecond, true},
+		{"allowed when the block ends", 200 * time.Millisecond, false},
+		{"window counts again", 0, false},
+		{"blocked again", 0, true},
+	}
+
+	for _, step := range steps {
+		clock.Advance(step.advance)
+
+		exceeded, err := limiter.IsRateLimitExceeded(ctx, "127.0.0.1", false)
+		assert.NoError(t, err, step.name)
+		assert.Equal(t, step.exceeded, exceeded, step.name)
+	}
+}
@@ -0,0 +1,47 @@
+package ratelimiter
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Acquire takes one of the limit slots of key, shared by every instance
+// through the store, and returns the function that gives it back. Each slot
+// is held as a hit that expires after lease, so the slots of an instance that
+// dies without releasing them come back on their own.
+//
+// The slot is recorded before the slots are counted and given back when the
+// count is over the limit, so two instances racing for the last slot can't
+// both take it. At worst both give it back and the caller retries.
+func (l *RateLimiter) Acquire(ctx context.Context, key string, limit int64, lease time.Duration) (func(context.Context) error, bool, error) {
+	storeKey := l.storeKey(key)
+	now := l.clock.Now()
+
+	hitID := l.newHitID(now)
+	if err := l.Store.RecordHit(ctx, storeKey, hitID, now.Add(lease), now); err != nil {
+		return nil, false, err
+	}
+
+	count, err := l.Store.CountInWindow(ctx, storeKey, now)
+	if err == nil && count <= limit {
+		return l.releaseFunc(storeKey, hitID), true, nil
+	}
+
+	if removeErr := l.Store.RemoveHit(ctx, storeKey, hitID); removeErr != nil {
+		l.logger.Printf("Error giving back the slot of key %s: %v", l.logKey(key), removeErr)
+	}
+	return nil, false, err
+}
+
+// releaseFunc returns the function that gives the slot of hitID back once.
+func (l *RateLimiter) releaseFunc(storeKey, hitID string) func(context.Context) error {
+	var once sync.Once
+	return func(ctx context.Context) error {
+		var err error
+		once.Do(func() {
+			err = l.Store.RemoveHit(ctx, storeKey, hitID)
+		})
+		return err
+	}
+}
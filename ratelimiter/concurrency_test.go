@@ -0,0 +1,71 @@
+package ratelimiter
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAcquire(t *testing.T) {
+	ctx := context.Background()
+	limiter, clock := newFakeLimiter(10, time.Second)
+
+	release, ok, err := limiter.Acquire(ctx, "conns", 2, time.Minute)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	_, ok, err = limiter.Acquire(ctx, "conns", 2, time.Minute)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	_, ok, err = limiter.Acquire(ctx, "conns", 2, time.Minute)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	assert.NoError(t, release(ctx))
+	assert.NoError(t, release(ctx), "releasing twice must not free a second slot")
+
+	_, ok, err = limiter.Acquire(ctx, "conns", 2, time.Minute)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	_, ok, err = limiter.Acquire(ctx, "conns", 2, time.Minute)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	clock.Advance(time.Minute)
+
+	_, ok, err = limiter.Acquire(ctx, "conns", 2, time.Minute)
+	assert.NoError(t, err)
+	assert.True(t, ok, "slots must come back once their lease ends")
+}
+
+func TestAcquire_Concurrent(t *testing.T) {
+	ctx := context.Background()
+	limiter, _ := newFakeLimiter(10, time.Second)
+
+	var acquired atomic.Int64
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, ok, err := limiter.Acquire(ctx, "conns", 3, time.Minute)
+			assert.NoError(t, err)
+			if ok {
+				acquired.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.LessOrEqual(t, acquired.Load(), int64(3), "racing callers must never take more slots than the limit")
+
+	count, err := limiter.Store.CountInWindow(ctx, limiter.storeKey("conns"), limiter.clock.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, acquired.Load(), count, "denied callers must give their slot back")
+}
@@ -0,0 +1,170 @@
+package ratelimiter
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jpodlasnisky/ratelimiter/infra/database/contract_db"
+)
+
+// Decision is the outcome of CheckN, with enough detail for a remote caller
+// to build its own response.
+type Decision struct {
+	Allowed    bool
+	Limit      int64
+	Remaining  int64
+	RetryAfter time.Duration
+}
+
+// IPLimit returns the limit of requests per window of clients without a token.
+func (l *RateLimiter) IPLimit() int64 {
+	return l.ipLimit
+}
+
+// Window returns the length of the window the hits are counted in.
+func (l *RateLimiter) Window() time.Duration {
+	return l.window
+}
+
+// PolicyLimit returns the limit per window stored for the token, or the limit
+// of the token it replaces during a rotation. It returns ErrTokenExpired or
+// ErrTokenNotYetValid when the token can't be used now.
+func (l *RateLimiter) PolicyLimit(ctx context.Context, token string) (int64, error) {
+	bucket, err := l.tokenBucket(token)
+	if err != nil {
+		return 0, err
+	}
+	return l.limitFor(ctx, l.storeKey(bucket), true)
+}
+
+// limitOf returns the limit of key: the policy of a token or the IP limit.
+func (l *RateLimiter) limitOf(ctx context.Context, key string) (int64, error) {
+	if l.TokenExists(key) {
+		return l.PolicyLimit(ctx, key)
+	}
+	return l.ipLimit, nil
+}
+
+// CheckN counts cost hits for key against limit, the same way
+// IsRateLimitExceeded counts one request: going over the limit blocks the key
+// for the block duration. A cost above the limit is denied without blocking.
+func (l *RateLimiter) CheckN(ctx context.Context, key string, limit int64, cost int) (Decision, error) {
+	return l.CheckNInWindow(ctx, key, limit, cost, l.window)
+}
+
+// CheckNInWindow is CheckN with hits counted in the given window instead of
+// the window of the limiter, e.g. to count rare events over minutes. A token
+// that can't be used now gets ErrTokenExpired or ErrTokenNotYetValid.
+func (l *RateLimiter) CheckNInWindow(ctx context.Context, key string, limit int64, cost int, window time.Duration) (Decision, error) {
+	if l.TokenExists(key) {
+		bucket, err := l.tokenBucket(key)
+		if err != nil {
+			return Decision{Limit: limit}, err
+		}
+		key = bucket
+	}
+
+	decision, err := l.checkN(ctx, key, limit, cost, window)
+	if err != nil {
+		if err = l.handleStoreError(key, err); err != nil {
+			return Decision{}, err
+		}
+		return Decision{Allowed: true, Limit: limit}, nil
+	}
+
+	l.metrics.RecordDecision(key, l.TokenExists(key), !decision.Allowed)
+	return decision, nil
+}
+
+// checkN records the hits before it counts the window and removes them when
+// they don't fit, like Acquire, so concurrent checks can't all take the last
+// hits of the window.
+func (l *RateLimiter) checkN(ctx context.Context, key string, limit int64, cost int, window time.Duration) (Decision, error) {
+	if cost < 0 {
+		return Decision{Limit: limit}, ErrNegativeHits
+	}
+	decision := Decision{Limit: limit}
+
+	// Um token de um tenant também conta no projeto e na organização
+	levels := l.levelsFor(key, limit, l.TokenExists(key))
+	for _, lvl := range levels {
+		if int64(cost) > lvl.limit {
+			return decision, nil
+		}
+	}
+
+	now := l.clock.Now()
+	for _, lvl := range levels {
+		blockedUntil, isBlocked, err := l.blockedUntil(ctx, lvl.key)
+		if err != nil {
+			return decision, err
+		}
+		if isBlocked {
+			decision.RetryAfter = blockedUntil.Sub(now)
+			return decision, nil
+		}
+	}
+
+	hitIDs := l.newHitIDs(now, cost)
+	var recorded []string
+	for _, lvl := range levels {
+		storeKey := l.storeKey(lvl.key)
+		if err := l.Store.RecordHits(ctx, storeKey, hitIDs, l.hitExpiryIn(now, window), now); err != nil {
+			l.removeHits(ctx, key, recorded, hitIDs)
+			return Decision{Limit: limit}, err
+		}
+		recorded = append(recorded, storeKey)
+	}
+
+	decision.Remaining = limit
+	for i, lvl := range levels {
+		count, err := l.Store.CountInWindow(ctx, l.storeKey(lvl.key), now)
+		if err != nil {
+			l.removeHits(ctx, key, recorded, hitIDs)
+			return Decision{Limit: limit}, err
+		}
+
+		if count > lvl.limit && i > 0 {
+			// Um nível compartilhado só recusa até a janela liberar espaço
+			l.removeHits(ctx, key, recorded, hitIDs)
+			freeAt, err := l.Store.HitExpiryAt(ctx, l.storeKey(lvl.key), count-lvl.limit-1)
+			if err != nil && !errors.Is(err, contract_db.ErrNotFound) {
+				return Decision{Limit: limit}, err
+			}
+			l.logger.Printf("key full: %s count: %d, cost: %d, reqLimit: %d \n", l.logKey(lvl.key), count-int64(cost), cost, lvl.limit)
+
+			decision := Decision{Limit: limit}
+			if freeAt.After(now) {
+				decision.RetryAfter = freeAt.Sub(now)
+			}
+			return decision, nil
+		}
+		if count > lvl.limit {
+			l.removeHits(ctx, key, recorded, hitIDs)
+			if err := l.BlockKey(ctx, lvl.key); err != nil {
+				return Decision{Limit: limit}, err
+			}
+			l.logger.Printf("key blocked: %s count: %d, cost: %d, reqLimit: %d \n", l.logKey(lvl.key), count-int64(cost), cost, lvl.limit)
+
+			return Decision{Limit: limit, RetryAfter: l.blockDuration}, nil
+		}
+
+		if remaining := lvl.limit - count; remaining < decision.Remaining {
+			decision.Remaining = remaining
+		}
+	}
+
+	decision.Allowed = true
+	return decision, nil
+}
+
+// removeHits gives the hits back to the windows of storeKeys after they were
+// found not to fit.
+func (l *RateLimiter) removeHits(ctx context.Context, key string, storeKeys, hitIDs []string) {
+	for _, storeKey := range storeKeys {
+		if err := l.Store.RemoveHits(ctx, storeKey, hitIDs); err != nil {
+			l.logger.Printf("Error giving back the hits of key %s: %v", l.logKey(key), err)
+		}
+	}
+}
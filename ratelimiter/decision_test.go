@@ -0,0 +1,86 @@
+package ratelimiter
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckN(t *testing.T) {
+	ctx := context.Background()
+	limiter, clock := newFakeLimiter(5, time.Second)
+
+	decision, err := limiter.CheckN(ctx, "service", 5, 3)
+	assert.NoError(t, err)
+	assert.Equal(t, Decision{Allowed: true, Limit: 5, Remaining: 2}, decision)
+
+	decision, err = limiter.CheckN(ctx, "service", 5, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, Decision{Allowed: true, Limit: 5, Remaining: 0}, decision)
+
+	decision, err = limiter.CheckN(ctx, "service", 5, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, Decision{Allowed: false, Limit: 5, RetryAfter: time.Minute}, decision)
+
+	clock.Advance(10 * time.Second)
+
+	decision, err = limiter.CheckN(ctx, "service", 5, 1)
+	assert.NoError(t, err)
+	assert.False(t, decision.Allowed)
+	assert.Equal(t, 50*time.Second, decision.RetryAfter, "a blocked key must report the rest of the block")
+}
+
+func TestCheckN_CostAboveLimit(t *testing.T) {
+	ctx := context.Background()
+	limiter, _ := newFakeLimiter(5, time.Second)
+
+	decision, err := limiter.CheckN(ctx, "service", 2, 3)
+	assert.NoError(t, err)
+	assert.False(t, decision.Allowed)
+
+	blocked, err := limiter.IsKeyBlocked(ctx, "service")
+	assert.NoError(t, err)
+	assert.False(t, blocked, "a cost that can never fit must not block the key")
+}
+
+func TestPolicyLimit(t *testing.T) {
+	ctx := context.Background()
+	limiter := New(newMemoryStore(SystemClock()), WithPolicies(map[string]int64{"token": 7}))
+	assert.NoError(t, limiter.RegisterPersonalizedTokens(ctx))
+
+	limit, err := limiter.PolicyLimit(ctx, "token")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(7), limit)
+
+	_, err = limiter.PolicyLimit(ctx, "missing")
+	assert.Error(t, err)
+}
+
+func TestCheckNInWindow(t *testing.T) {
+	ctx := context.Background()
+	limiter, clock := newFakeLimiter(5, time.Second)
+
+	for i := 0; i < 2; i++ {
+		decision, err := limiter.CheckNInWindow(ctx, "failed", 2, 1, time.Minute)
+		assert.NoError(t, err)
+		assert.True(t, decision.Allowed)
+		clock.Advance(10 * time.Second)
+	}
+
+	decision, err := limiter.CheckNInWindow(ctx, "failed", 2, 1, time.Minute)
+	assert.NoError(t, err)
+	assert.False(t, decision.Allowed, "hits must be counted for the whole given window")
+}
+
+func TestCheckN_RecordsCostInOneCall(t *testing.T) {
+	ctx := context.Background()
+	store := &countingStore{memoryStore: newMemoryStore(SystemClock())}
+	limiter := New(store, WithWindow(time.Minute))
+
+	decision, err := limiter.CheckN(ctx, "service", 10000, 5000)
+	assert.NoError(t, err)
+	assert.True(t, decision.Allowed)
+	assert.Equal(t, 1, store.writes)
+}
@@ -0,0 +1,66 @@
+package ratelimiter
+
+import "strings"
+
+// Tenant places a token in a project of an organization, whose limits are
+// shared by all of their tokens. Project may be empty.
+type Tenant struct {
+	Organization string
+	Project      string
+}
+
+// ParseTenant reads a tenant written as "organization/project" or "organization".
+func ParseTenant(value string) Tenant {
+	organization, project, _ := strings.Cut(value, "/")
+	return Tenant{Organization: strings.TrimSpace(organization), Project: strings.TrimSpace(project)}
+}
+
+// level is one of the budgets a request is counted against.
+type level struct {
+	key   string
+	limit int64
+}
+
+// WithTenants sets the tenant of each token. A request of the token is also
+// counted against the limits of its organization and project, set by
+// WithTenantLimits, and is rejected when any of them is exhausted.
+func WithTenants(tenants map[string]Tenant) Option {
+	return func(l *RateLimiter) {
+		l.tenants = tenants
+	}
+}
+
+// WithTenantLimits sets the limit per window of each organization and of each
+// project, the latter named "organization/project". Levels without a limit
+// aren't counted.
+func WithTenantLimits(organizations, projects map[string]int64) Option {
+	return func(l *RateLimiter) {
+		l.organizationLimits = organizations
+		l.projectLimits = projects
+	}
+}
+
+// levelsFor returns the budgets a request of key is counted against, the key
+// itself first and then its project and organization.
+func (l *RateLimiter) levelsFor(key string, limit int64, isToken bool) []level {
+	levels := []level{{key: key, limit: limit}}
+	if !isToken {
+		return levels
+	}
+
+	tenant, exists := l.tenants[key]
+	if !exists {
+		return levels
+	}
+
+	if tenant.Project != "" {
+		project := tenant.Organization + "/" + tenant.Project
+		if projectLimit, exists := l.projectLimits[project]; exists {
+			levels = append(levels, level{key: KindProject.Key(project), limit: projectLimit})
+		}
+	}
+	if organizationLimit, exists := l.organizationLimits[tenant.Organization]; exists {
+		levels = append(levels, level{key: KindOrganization.Key(tenant.Organization), limit: organizationLimit})
+	}
+	return levels
+}
@@ -0,0 +1,142 @@
+package ratelimiter
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTenantLimiter(t *testing.T) (*RateLimiter, *FakeClock) {
+	clock := NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	limiter := New(newMemoryStore(clock),
+		WithClock(clock),
+		WithPolicies(map[string]int64{"web-1": 3, "web-2": 3, "mobile": 3, "other": 3}),
+		WithTenants(map[string]Tenant{
+			"web-1":  ParseTenant("acme/web"),
+			"web-2":  ParseTenant("acme/web"),
+			"mobile": ParseTenant("acme/mobile"),
+		}),
+		WithTenantLimits(map[string]int64{"acme": 5}, map[string]int64{"acme/web": 4}),
+	)
+	assert.NoError(t, limiter.RegisterPersonalizedTokens(context.Background()))
+	return limiter, clock
+}
+
+func exceeded(t *testing.T, limiter *RateLimiter, token string) bool {
+	isExceeded, err := limiter.IsRateLimitExceeded(context.Background(), token, true)
+	assert.NoError(t, err)
+	return isExceeded
+}
+
+func TestHierarchy_ProjectLimit(t *testing.T) {
+	limiter, _ := newTenantLimiter(t)
+
+	for i := 0; i < 2; i++ {
+		assert.False(t, exceeded(t, limiter, "web-1"))
+		assert.False(t, exceeded(t, limiter, "web-2"))
+	}
+
+	assert.True(t, exceeded(t, limiter, "web-1"), "the keys of a project must share its limit")
+	assert.True(t, exceeded(t, limiter, "web-2"), "a blocked project blocks all of its keys")
+	assert.False(t, exceeded(t, limiter, "mobile"), "other projects keep their budget")
+}
+
+func TestHierarchy_OrganizationLimit(t *testing.T) {
+	limiter, clock := newTenantLimiter(t)
+
+	for i := 0; i < 3; i++ {
+		assert.False(t, exceeded(t, limiter, "mobile"))
+	}
+	assert.False(t, exceeded(t, limiter, "web-1"))
+	assert.False(t, exceeded(t, limiter, "web-2"))
+
+	assert.True(t, exceeded(t, limiter, "web-1"), "the organization has no budget left")
+	assert.False(t, exceeded(t, limiter, "other"), "tokens without tenant are limited on their own")
+
+	clock.Advance(2 * time.Minute)
+	assert.False(t, exceeded(t, limiter, "web-1"), "the organization block must end")
+}
+
+func TestHierarchy_KeyLimitDoesNotSpendTenant(t *testing.T) {
+	limiter, _ := newTenantLimiter(t)
+
+	for i := 0; i < 3; i++ {
+		assert.False(t, exceeded(t, limiter, "mobile"))
+	}
+	assert.True(t, exceeded(t, limiter, "mobile"))
+
+	assert.False(t, exceeded(t, limiter, "web-1"))
+	assert.False(t, exceeded(t, limiter, "web-2"), "a rejected request must not be counted by the organization")
+}
+
+func TestParseTenant(t *testing.T) {
+	assert.Equal(t, Tenant{Organization: "acme", Project: "web"}, ParseTenant("acme/web"))
+	assert.Equal(t, Tenant{Organization: "acme"}, ParseTenant("acme"))
+}
+
+func TestHierarchy_CheckN(t *testing.T) {
+	ctx := context.Background()
+	limiter, _ := newTenantLimiter(t)
+
+	decision, err := limiter.CheckN(ctx, "web-1", 3, 3)
+	assert.NoError(t, err)
+	assert.True(t, decision.Allowed)
+	assert.Equal(t, int64(0), decision.Remaining)
+
+	decision, err = limiter.CheckN(ctx, "web-2", 3, 2)
+	assert.NoError(t, err)
+	assert.False(t, decision.Allowed, "the decision service must count the project budget too")
+	assert.Equal(t, time.Second, decision.RetryAfter, "a full project frees up with its window")
+
+	assert.False(t, exceeded(t, limiter, "web-2"), "the project is not blocked: its last hit is still free")
+	assert.True(t, exceeded(t, limiter, "web-2"), "the middleware counts the same project")
+}
+
+func TestHierarchy_FullLevelIsNotBlocked(t *testing.T) {
+	limiter, clock := newTenantLimiter(t)
+
+	for i := 0; i < 2; i++ {
+		assert.False(t, exceeded(t, limiter, "web-1"))
+		assert.False(t, exceeded(t, limiter, "web-2"))
+	}
+	assert.True(t, exceeded(t, limiter, "web-2"))
+
+	blocked, err := limiter.IsKeyBlocked(context.Background(), KindProject.Key("acme/web"))
+	assert.NoError(t, err)
+	assert.False(t, blocked, "a shared level must not be blocked for the block duration")
+
+	clock.Advance(time.Second)
+	assert.False(t, exceeded(t, limiter, "web-2"), "the project takes requests again once its window frees up")
+}
+
+func TestHierarchy_Allow(t *testing.T) {
+	ctx := context.Background()
+	limiter, _ := newTenantLimiter(t)
+
+	for i := 0; i < 3; i++ {
+		allowed, err := limiter.Allow(ctx, "mobile")
+		assert.NoError(t, err)
+		assert.True(t, allowed)
+	}
+	for i := 0; i < 2; i++ {
+		allowed, err := limiter.Allow(ctx, "web-1")
+		assert.NoError(t, err)
+		assert.True(t, allowed)
+	}
+
+	allowed, err := limiter.Allow(ctx, "web-2")
+	assert.NoError(t, err)
+	assert.False(t, allowed, "Allow must count the organization budget too")
+
+	r, err := limiter.Reserve(ctx, "web-2")
+	assert.NoError(t, err)
+	assert.True(t, r.OK())
+	assert.Greater(t, r.Delay(), time.Duration(0), "the reservation waits for the organization window")
+
+	assert.NoError(t, r.Cancel(ctx))
+	count, err := limiter.Store.CountInWindow(ctx, "org:acme", limiter.clock.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, int64(5), count, "cancelling gives the hit back to every level")
+}
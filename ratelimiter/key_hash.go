@@ -0,0 +1,56 @@
+package ratelimiter
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// hashKey returns the HMAC-SHA256 of the key, in hex, when a secret is set,
+// so tokens never reach the store in plain text.
+func (l *RateLimiter) hashKey(key string) string {
+	if len(l.keyHashSecret) == 0 {
+		return key
+	}
+
+	mac := hmac.New(sha256.New, l.keyHashSecret)
+	mac.Write([]byte(key))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// logKey returns how the key appears in the logs: hashed like in the store
+// when a secret is set, so the logs don't leak tokens either.
+func (l *RateLimiter) logKey(key string) string {
+	return l.hashKey(key)
+}
+
+// MigratePlaintextTokens moves the state of every configured token from its
+// plain text key to its hashed key: a block still running is carried over and
+// the plain text window, block and policy are deleted. The windows are not
+// carried, as they last a few seconds. It does nothing without a secret and
+// can run on every start.
+func (l *RateLimiter) MigratePlaintextTokens(ctx context.Context) error {
+	if len(l.keyHashSecret) == 0 {
+		return nil
+	}
+
+	for token := range l.ConfigToken {
+		plainKey := l.keyPrefix + token
+
+		isBlocked, remaining, err := l.Store.IsBlocked(ctx, plainKey)
+		if err != nil {
+			return err
+		}
+		if isBlocked && remaining > 0 {
+			if err := l.BlockKeyFor(ctx, token, remaining); err != nil {
+				return err
+			}
+		}
+
+		if err := l.Store.Purge(ctx, plainKey); err != nil {
+			return err
+		}
+	}
+	return nil
+}
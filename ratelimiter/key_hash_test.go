@@ -0,0 +1,110 @@
+package ratelimiter
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWithKeyHashSecret_NoPlaintextTokens(t *testing.T) {
+	ctx := context.Background()
+	store := newMemoryStore(SystemClock())
+	limiter := New(store,
+		WithPolicies(map[string]int64{"secret_token": 1}),
+		WithKeyHashSecret([]byte("server-secret")),
+	)
+	assert.NoError(t, limiter.RegisterPersonalizedTokens(ctx))
+
+	for i := 0; i < 2; i++ {
+		_, err := limiter.IsRateLimitExceeded(ctx, "secret_token", true)
+		assert.NoError(t, err)
+	}
+
+	blocked, err := limiter.IsKeyBlocked(ctx, "secret_token")
+	assert.NoError(t, err)
+	assert.True(t, blocked)
+
+	var keys []string
+	for key, policy := range store.policies {
+		keys = append(keys, key)
+		assert.Empty(t, policy.Token)
+	}
+	for key := range store.hits {
+		keys = append(keys, key)
+	}
+	for key := range store.blocks {
+		keys = append(keys, key)
+	}
+
+	assert.Len(t, keys, 3)
+	for _, key := range keys {
+		assert.False(t, strings.Contains(key, "secret_token"), key)
+		assert.Equal(t, limiter.hashKey("secret_token"), key)
+	}
+}
+
+func TestWithKeyHashSecret_NoPlaintextTokensInLogs(t *testing.T) {
+	ctx := context.Background()
+	var logs bytes.Buffer
+	limiter := New(newMemoryStore(SystemClock()),
+		WithPolicies(map[string]int64{"secret_token": 1}),
+		WithKeyHashSecret([]byte("server-secret")),
+		WithLogger(log.New(&logs, "", 0)),
+	)
+	assert.NoError(t, limiter.RegisterPersonalizedTokens(ctx))
+
+	for i := 0; i < 2; i++ {
+		_, err := limiter.IsRateLimitExceeded(ctx, "secret_token", true)
+		assert.NoError(t, err)
+	}
+	_, err := limiter.CheckN(ctx, "secret_token", 1, 1)
+	assert.NoError(t, err)
+
+	assert.Contains(t, logs.String(), limiter.hashKey("secret_token"))
+	assert.NotContains(t, logs.String(), "secret_token")
+}
+
+func TestHashKey(t *testing.T) {
+	plain := New(newMemoryStore(SystemClock()))
+	assert.Equal(t, "token", plain.hashKey("token"))
+
+	hashed := New(newMemoryStore(SystemClock()), WithKeyHashSecret([]byte("secret")))
+	other := New(newMemoryStore(SystemClock()), WithKeyHashSecret([]byte("other")))
+
+	assert.Len(t, hashed.hashKey("token"), 64)
+	assert.Equal(t, hashed.hashKey("token"), hashed.hashKey("token"))
+	assert.NotEqual(t, hashed.hashKey("token"), other.hashKey("token"), "the hash must depend on the secret")
+}
+
+func TestMigratePlaintextTokens(t *testing.T) {
+	ctx := context.Background()
+	clock := NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	store := newMemoryStore(clock)
+	policies := map[string]int64{"secret_token": 5}
+
+	plain := New(store, WithClock(clock), WithPolicies(policies))
+	assert.NoError(t, plain.RegisterPersonalizedTokens(ctx))
+	assert.NoError(t, plain.BlockKey(ctx, "secret_token"))
+
+	clock.Advance(20 * time.Second)
+
+	hashed := New(store, WithClock(clock), WithPolicies(policies), WithKeyHashSecret([]byte("server-secret")))
+	assert.NoError(t, hashed.MigratePlaintextTokens(ctx))
+	assert.NoError(t, hashed.RegisterPersonalizedTokens(ctx))
+
+	_, exists := store.policies["secret_token"]
+	assert.False(t, exists, "the plain text policy must be deleted")
+	_, exists = store.blocks["secret_token"]
+	assert.False(t, exists, "the plain text block must be deleted")
+
+	r, err := hashed.Reserve(ctx, "secret_token")
+	assert.NoError(t, err)
+	assert.Equal(t, 40*time.Second, r.Delay(), "the rest of the block must be carried over")
+
+	assert.NoError(t, hashed.MigratePlaintextTokens(ctx), "migrating twice must be harmless")
+}
@@ -0,0 +1,38 @@
+package ratelimiter
+
+import (
+	"net/url"
+	"strings"
+)
+
+// KeyKind is a family of keys counted by the limiter besides the tokens and
+// IPs, such as the identities of JWTs or the quotas of outbound calls.
+type KeyKind string
+
+const (
+	KindOrganization KeyKind = "org"
+	KindProject      KeyKind = "project"
+	KindJWT          KeyKind = "jwt"
+	KindCert         KeyKind = "cert"
+	KindKeyRule      KeyKind = "rule"
+	KindFailedToken  KeyKind = "failed-token"
+	KindCheck        KeyKind = "check"
+	KindRLS          KeyKind = "rls"
+	KindMethod       KeyKind = "method"
+	KindStream       KeyKind = "stream"
+	KindOutbound     KeyKind = "outbound"
+	KindConns        KeyKind = "conns"
+)
+
+// Key builds the key of the kind from its parts, as "kind:part:part". Each
+// part is escaped, so no input can forge a separator, another kind or a Redis
+// Cluster hash tag.
+func (k KeyKind) Key(parts ...string) string {
+	var b strings.Builder
+	b.WriteString(string(k))
+	for _, part := range parts {
+		b.WriteByte(':')
+		b.WriteString(url.QueryEscape(part))
+	}
+	return b.String()
+}
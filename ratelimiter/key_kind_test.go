@@ -0,0 +1,18 @@
+package ratelimiter
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeyKind_Key(t *testing.T) {
+	assert.Equal(t, "outbound:api.vendor.com%3A443", KindOutbound.Key("api.vendor.com:443"))
+	assert.Equal(t, "check:user:42", KindCheck.Key("user", "42"))
+	assert.Equal(t, "rls:edge:api_key:%7BTOKEN%7D", KindRLS.Key("edge", "api_key", "{TOKEN}"))
+}
+
+func TestKeyKind_KeyCantBeForged(t *testing.T) {
+	assert.NotEqual(t, KindCheck.Key("a:b", "c"), KindCheck.Key("a", "b:c"))
+	assert.NotEqual(t, KindMethod.Key("/svc/Get", "ip|x"), KindMethod.Key("/svc/Get|ip", "x"))
+}
@@ -0,0 +1,257 @@
+package ratelimiter
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jpodlasnisky/ratelimiter/infra/database/contract_db"
+	"github.com/stretchr/testify/assert"
+)
+
+// memoryStore is a LimiterStore that keeps everything in maps, with nothing
+// shaped after Redis.
+type memoryStore struct {
+	clock    Clock
+	mu       sync.Mutex
+	hits     map[string][]memoryHit
+	blocks   map[string]time.Time
+	policies map[string]contract_db.Policy
+}
+
+type memoryHit struct {
+	id        string
+	expiresAt time.Time
+}
+
+func newMemoryStore(clock Clock) *memoryStore {
+	return &memoryStore{
+		clock:    clock,
+		hits:     make(map[string][]memoryHit),
+		blocks:   make(map[string]time.Time),
+		policies: make(map[string]contract_db.Policy),
+	}
+}
+
+func (s *memoryStore) RecordHit(ctx context.Context, key, hitID string, expiresAt, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.hits[key] = append(s.hits[key], memoryHit{id: hitID, expiresAt: expiresAt})
+	sort.SliceStable(s.hits[key], func(i, j int) bool {
+		return s.hits[key][i].expiresAt.Before(s.hits[key][j].expiresAt)
+	})
+	return nil
+}
+
+func (s *memoryStore) RecordHits(ctx context.Context, key string, hitIDs []string, expiresAt, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, hitID := range hitIDs {
+		s.hits[key] = append(s.hits[key], memoryHit{id: hitID, expiresAt: expiresAt})
+	}
+	sort.SliceStable(s.hits[key], func(i, j int) bool {
+		return s.hits[key][i].expiresAt.Before(s.hits[key][j].expiresAt)
+	})
+	return nil
+}
+
+func (s *memoryStore) RemoveHits(ctx context.Context, key string, hitIDs []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	removed := make(map[string]bool, len(hitIDs))
+	for _, hitID := range hitIDs {
+		removed[hitID] = true
+	}
+
+	var remaining []memoryHit
+	for _, hit := range s.hits[key] {
+		if !removed[hit.id] {
+			remaining = append(remaining, hit)
+		}
+	}
+	s.hits[key] = remaining
+	return nil
+}
+
+func (s *memoryStore) RemoveHit(ctx context.Context, key, hitID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, hit := range s.hits[key] {
+		if hit.id == hitID {
+			s.hits[key] = append(s.hits[key][:i], s.hits[key][i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) HitExpiryAt(ctx context.Context, key string, index int64) (time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if index >= int64(len(s.hits[key])) {
+		return time.Time{}, contract_db.ErrNotFound
+	}
+	return s.hits[key][index].expiresAt, nil
+}
+
+func (s *memoryStore) WeightInWindow(ctx context.Context, key string, now time.Time) (int64, error) {
+	if _, err := s.CountInWindow(ctx, key, now); err != nil {
+		return 0, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var weight int64
+	for _, hit := range s.hits[key] {
+		weight += contract_db.HitWeight(hit.id)
+	}
+	return weight, nil
+}
+
+func (s *memoryStore) WeightExpiryAt(ctx context.Context, key string, weight int64) (time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var left int64
+	for _, hit := range s.hits[key] {
+		if left += contract_db.HitWeight(hit.id); left >= weight {
+			return hit.expiresAt, nil
+		}
+	}
+	return time.Time{}, contract_db.ErrNotFound
+}
+
+func (s *memoryStore) CountInWindow(ctx context.Context, key string, now time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var remaining []memoryHit
+	for _, hit := range s.hits[key] {
+		if hit.expiresAt.After(now) {
+			remaining = append(remaining, hit)
+		}
+	}
+	s.hits[key] = remaining
+	return int64(len(remaining)), nil
+}
+
+func (s *memoryStore) Block(ctx context.Context, key string, duration time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.blocks[key] = s.clock.Now().Add(duration)
+	return nil
+}
+
+func (s *memoryStore) IsBlocked(ctx context.Context, key string) (bool, time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	remaining := s.blocks[key].Sub(s.clock.Now())
+	if remaining <= 0 {
+		return false, 0, nil
+	}
+	return true, remaining, nil
+}
+
+func (s *memoryStore) Unblock(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.blocks, key)
+	return nil
+}
+
+func (s *memoryStore) WatchUnblocks(ctx context.Context) (<-chan string, error) {
+	keys := make(chan string)
+	go func() {
+		<-ctx.Done()
+		close(keys)
+	}()
+	return keys, nil
+}
+
+func (s *memoryStore) GetPolicy(ctx context.Context, key string) (contract_db.Policy, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	policy, ok := s.policies[key]
+	if !ok {
+		return contract_db.Policy{}, contract_db.ErrNotFound
+	}
+	return policy, nil
+}
+
+func (s *memoryStore) PutPolicy(ctx context.Context, key string, policy contract_db.Policy, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.policies[key] = policy
+	return nil
+}
+
+func (s *memoryStore) Purge(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.hits, key)
+	delete(s.blocks, key)
+	delete(s.policies, key)
+	return nil
+}
+
+func (s *memoryStore) Reset(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.hits, key)
+	delete(s.blocks, key)
+	return nil
+}
+
+func (s *memoryStore) Keys(ctx context.Context, prefix string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	seen := make(map[string]bool)
+	for key := range s.hits {
+		seen[key] = true
+	}
+	for key := range s.blocks {
+		seen[key] = true
+	}
+	for key := range s.policies {
+		seen[key] = true
+	}
+
+	var keys []string
+	for key := range seen {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func TestLimiterStore_BlocksAfterLimit(t *testing.T) {
+	ctx := context.Background()
+	limiter := New(newMemoryStore(SystemClock()), WithPolicies(map[string]int64{"test_token": 2}), WithWindow(time.Minute))
+
+	assert.NoError(t, limiter.RegisterPersonalizedTokens(ctx))
+
+	for i := 0; i < 2; i++ {
+		exceeded, err := limiter.IsRateLimitExceeded(ctx, "test_token", true)
+		assert.NoError(t, err)
+		assert.False(t, exceeded)
+	}
+
+	exceeded, err := limiter.IsRateLimitExceeded(ctx, "test_token", true)
+	assert.NoError(t, err)
+	assert.True(t, exceeded)
+
+	assert.NoError(t, limiter.UnblockKey(ctx, "test_token"))
+	blocked, err := limiter.IsKeyBlocked(ctx, "test_token")
+	assert.NoError(t, err)
+	assert.False(t, blocked)
+}
+
+func TestLimiterStore_UnknownToken(t *testing.T) {
+	limiter := New(newMemoryStore(SystemClock()))
+
+	_, err := limiter.IsRateLimitExceeded(context.Background(), "unknown", true)
+	assert.EqualError(t, err, "token não encontrado")
+}
@@ -0,0 +1,56 @@
+package ratelimiter
+
+import (
+	"context"
+	"errors"
+	"strings"
+)
+
+// ErrNoNamespace is returned by the namespace operations of a limiter
+// without a key prefix, which would reach every key of the store.
+var ErrNoNamespace = errors.New("ratelimiter: no namespace set")
+
+// WithNamespace prefixes every key the limiter writes with the environment
+// and the application, as "env:app:", so applications sharing a store don't
+// collide. Empty parts are left out.
+func WithNamespace(env, app string) Option {
+	return WithKeyPrefix(Namespace(env, app))
+}
+
+// Namespace returns the key prefix of the environment and the application.
+func Namespace(env, app string) string {
+	var prefix strings.Builder
+	for _, part := range []string{env, app} {
+		if part != "" {
+			prefix.WriteString(part)
+			prefix.WriteString(":")
+		}
+	}
+	return prefix.String()
+}
+
+// NamespaceKeys lists the keys of the namespace of the limiter, as stored.
+func (l *RateLimiter) NamespaceKeys(ctx context.Context) ([]string, error) {
+	if l.keyPrefix == "" {
+		return nil, ErrNoNamespace
+	}
+	return l.Store.Keys(ctx, l.keyPrefix)
+}
+
+// PurgeNamespace deletes the window and the block of every key of the
+// namespace of the limiter and returns how many keys were purged. The policies
+// of the tokens are kept, so running instances go on finding them.
+func (l *RateLimiter) PurgeNamespace(ctx context.Context) (int, error) {
+	keys, err := l.NamespaceKeys(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	for i, key := range keys {
+		if err := l.Store.Reset(ctx, key); err != nil {
+			return i, err
+		}
+		l.blockCache.remove(key)
+	}
+	return len(keys), nil
+}
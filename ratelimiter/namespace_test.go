@@ -0,0 +1,61 @@
+package ratelimiter
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNamespace(t *testing.T) {
+	assert.Equal(t, "prod:checkout:", Namespace("prod", "checkout"))
+	assert.Equal(t, "checkout:", Namespace("", "checkout"))
+	assert.Equal(t, "", Namespace("", ""))
+}
+
+func TestNamespace_IsolatesApplications(t *testing.T) {
+	ctx := context.Background()
+	store := newMemoryStore(SystemClock())
+
+	checkout := New(store, WithNamespace("prod", "checkout"), WithIPLimit(1), WithPolicies(map[string]int64{"TOKEN_1": 5}))
+	search := New(store, WithNamespace("prod", "search"), WithIPLimit(1))
+	assert.NoError(t, checkout.RegisterPersonalizedTokens(ctx))
+
+	exceeded, err := checkout.IsRateLimitExceeded(ctx, "1.2.3.4", false)
+	assert.NoError(t, err)
+	assert.False(t, exceeded)
+
+	exceeded, err = search.IsRateLimitExceeded(ctx, "1.2.3.4", false)
+	assert.NoError(t, err)
+	assert.False(t, exceeded, "the same IP must have its own window in each application")
+
+	keys, err := checkout.NamespaceKeys(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"prod:checkout:1.2.3.4", "prod:checkout:TOKEN_1"}, keys)
+
+	purged, err := checkout.PurgeNamespace(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, purged)
+
+	keys, err = checkout.NamespaceKeys(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"prod:checkout:TOKEN_1"}, keys, "purging must keep the policies")
+
+	exceeded, err = checkout.IsRateLimitExceeded(ctx, "TOKEN_1", true)
+	assert.NoError(t, err)
+	assert.False(t, exceeded, "a running instance must still find the policy of the token")
+
+	keys, err = search.NamespaceKeys(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"prod:search:1.2.3.4"}, keys, "purging a namespace must keep the others")
+}
+
+func TestNamespace_Required(t *testing.T) {
+	limiter := New(newMemoryStore(SystemClock()))
+
+	_, err := limiter.NamespaceKeys(context.Background())
+	assert.ErrorIs(t, err, ErrNoNamespace)
+
+	_, err = limiter.PurgeNamespace(context.Background())
+	assert.ErrorIs(t, err, ErrNoNamespace)
+}
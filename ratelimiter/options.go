@@ -0,0 +1,137 @@
+package ratelimiter
+
+import "time"
+
+const (
+	defaultWindow        = time.Second
+	defaultBlockDuration = time.Minute
+	defaultIPLimit       = 10
+)
+
+// Algorithm decides how the hits of a key are counted in the window.
+type Algorithm int
+
+const (
+	// SlidingWindow counts the hits made in the last window, so each hit is
+	// forgotten one window after it was made.
+	SlidingWindow Algorithm = iota
+
+	// FixedWindow counts the hits made since the start of the current window,
+	// aligned to the clock, and forgets them all when it ends.
+	FixedWindow
+)
+
+// FailMode decides what happens to a request when the store fails.
+type FailMode int
+
+const (
+	// FailClosed returns the store error, so the request is rejected.
+	FailClosed FailMode = iota
+
+	// FailOpen logs the store error and lets the request through.
+	FailOpen
+)
+
+// Logger is satisfied by *log.Logger.
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+// Metrics receives every decision taken by the limiter.
+type Metrics interface {
+	RecordDecision(key string, isToken, limited bool)
+	RecordError(key string, err error)
+}
+
+type nopMetrics struct{}
+
+func (nopMetrics) RecordDecision(string, bool, bool) {}
+func (nopMetrics) RecordError(string, error)         {}
+
+// Option configures a RateLimiter built by New.
+type Option func(*RateLimiter)
+
+func WithAlgorithm(algorithm Algorithm) Option {
+	return func(l *RateLimiter) {
+		l.algorithm = algorithm
+	}
+}
+
+func WithClock(clock Clock) Option {
+	return func(l *RateLimiter) {
+		l.clock = clock
+	}
+}
+
+func WithLogger(logger Logger) Option {
+	return func(l *RateLimiter) {
+		l.logger = logger
+	}
+}
+
+func WithMetrics(metrics Metrics) Option {
+	return func(l *RateLimiter) {
+		l.metrics = metrics
+	}
+}
+
+// WithKeyPrefix prepends prefix to every key the limiter writes to the store.
+func WithKeyPrefix(prefix string) Option {
+	return func(l *RateLimiter) {
+		l.keyPrefix = prefix
+	}
+}
+
+// WithKeyHashSecret stores every key, tokens and IPs alike, as the
+// HMAC-SHA256 of the key with secret, so reading the store doesn't reveal the
+// tokens. See MigratePlaintextTokens for the keys written before.
+func WithKeyHashSecret(secret []byte) Option {
+	return func(l *RateLimiter) {
+		l.keyHashSecret = secret
+	}
+}
+
+func WithFailMode(mode FailMode) Option {
+	return func(l *RateLimiter) {
+		l.failMode = mode
+	}
+}
+
+// WithPolicies sets the limit of each token, in requests per window.
+func WithPolicies(policies map[string]int64) Option {
+	return func(l *RateLimiter) {
+		if policies == nil {
+			policies = map[string]int64{}
+		}
+		l.ConfigToken = policies
+	}
+}
+
+// WithIPLimit sets the limit of requests per window for clients without a token.
+func WithIPLimit(limit int64) Option {
+	return func(l *RateLimiter) {
+		l.ipLimit = limit
+	}
+}
+
+// WithWindow sets the length of the window the hits are counted in.
+func WithWindow(window time.Duration) Option {
+	return func(l *RateLimiter) {
+		l.window = window
+	}
+}
+
+// WithBlockDuration sets how long a key stays blocked after going over its limit.
+func WithBlockDuration(duration time.Duration) Option {
+	return func(l *RateLimiter) {
+		l.blockDuration = duration
+	}
+}
+
+// WithBlockCacheSize sets how many blocked keys are kept in memory. Zero
+// disables the local cache.
+func WithBlockCacheSize(size int) Option {
+	return func(l *RateLimiter) {
+		l.blockCache = newBlockCache(size)
+	}
+}
@@ -0,0 +1,131 @@
+package ratelimiter
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"testing"
+	"time"
+
+	"github.com/jpodlasnisky/ratelimiter/infra/database"
+	"github.com/jpodlasnisky/ratelimiter/infra/database/contract_db"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type recordingMetrics struct {
+	decisions []bool
+	errors    []error
+}
+
+func (m *recordingMetrics) RecordDecision(key string, isToken, limited bool) {
+	m.decisions = append(m.decisions, limited)
+}
+
+func (m *recordingMetrics) RecordError(key string, err error) {
+	m.errors = append(m.errors, err)
+}
+
+func TestNew_Defaults(t *testing.T) {
+	limiter := New(newMemoryStore(SystemClock()))
+
+	assert.Equal(t, defaultWindow, limiter.window)
+	assert.Equal(t, defaultBlockDuration, limiter.blockDuration)
+	assert.Equal(t, int64(defaultIPLimit), limiter.ipLimit)
+	assert.Equal(t, SlidingWindow, limiter.algorithm)
+	assert.Equal(t, FailClosed, limiter.failMode)
+	assert.NotNil(t, limiter.ConfigToken)
+}
+
+func TestNewLimiter_WrapsNew(t *testing.T) {
+	limiter := NewLimiter(new(database.MockRedisClient), map[string]int64{"token": 5}, 2, 30, 7)
+
+	assert.Equal(t, 2*time.Second, limiter.window)
+	assert.Equal(t, 30*time.Second, limiter.blockDuration)
+	assert.Equal(t, int64(7), limiter.ipLimit)
+	assert.True(t, limiter.TokenExists("token"))
+}
+
+func TestWithAlgorithm_FixedWindow(t *testing.T) {
+	ctx := context.Background()
+	clock := NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	limiter := New(newMemoryStore(clock),
+		WithAlgorithm(FixedWindow),
+		WithClock(clock),
+		WithWindow(10*time.Second),
+		WithIPLimit(2),
+	)
+
+	clock.Advance(8 * time.Second)
+	for i := 0; i < 2; i++ {
+		exceeded, err := limiter.IsRateLimitExceeded(ctx, "ip", false)
+		assert.NoError(t, err)
+		assert.False(t, exceeded)
+	}
+
+	// No sliding window os dois hits ainda contariam; no fixo a janela recomeça.
+	clock.Advance(2 * time.Second)
+	exceeded, err := limiter.IsRateLimitExceeded(ctx, "ip", false)
+	assert.NoError(t, err)
+	assert.False(t, exceeded)
+}
+
+func TestWithFailMode_FailOpen(t *testing.T) {
+	ctx := context.Background()
+	mockRedis := new(database.MockRedisClient)
+	metrics := &recordingMetrics{}
+	var logs bytes.Buffer
+	limiter := New(contract_db.NewDatastoreStore(mockRedis),
+		WithFailMode(FailOpen),
+		WithMetrics(metrics),
+		WithLogger(log.New(&logs, "", 0)),
+	)
+
+	mockRedis.On("Exists", ctx, []string{"block:{ip}"}).Return(int64(0), errors.New("connection refused"))
+
+	exceeded, err := limiter.IsRateLimitExceeded(ctx, "ip", false)
+	assert.NoError(t, err)
+	assert.False(t, exceeded)
+	assert.Len(t, metrics.errors, 1)
+	assert.Contains(t, logs.String(), "fail open")
+}
+
+func TestWithFailMode_FailClosed(t *testing.T) {
+	ctx := context.Background()
+	mockRedis := new(database.MockRedisClient)
+	limiter := New(contract_db.NewDatastoreStore(mockRedis))
+
+	mockRedis.On("Exists", ctx, []string{"block:{ip}"}).Return(int64(0), errors.New("connection refused"))
+
+	_, err := limiter.IsRateLimitExceeded(ctx, "ip", false)
+	assert.Error(t, err)
+}
+
+func TestWithKeyPrefix(t *testing.T) {
+	ctx := context.Background()
+	mockRedis := new(database.MockRedisClient)
+	limiter := New(contract_db.NewDatastoreStore(mockRedis), WithKeyPrefix("app:"), WithIPLimit(1))
+
+	mockRedis.On("Exists", ctx, []string{"block:{app:ip}"}).Return(int64(0), nil)
+	mockRedis.On("ZRemRangeByScore", ctx, "limiter:{app:ip}", "-inf", mock.Anything).Return(int64(0), nil)
+	mockRedis.On("ZCard", ctx, "limiter:{app:ip}").Return(int64(0), nil)
+	mockRedis.On("ZAdd", ctx, "limiter:{app:ip}", mock.Anything).Return(int64(1), nil)
+	mockRedis.On("ExtendTTL", ctx, "limiter:{app:ip}", mock.Anything).Return(nil)
+
+	exceeded, err := limiter.IsRateLimitExceeded(ctx, "ip", false)
+	assert.NoError(t, err)
+	assert.False(t, exceeded)
+	mockRedis.AssertExpectations(t)
+}
+
+func TestWithMetrics_RecordsDecisions(t *testing.T) {
+	ctx := context.Background()
+	metrics := &recordingMetrics{}
+	limiter := New(newMemoryStore(SystemClock()), WithMetrics(metrics), WithIPLimit(1))
+
+	limiter.IsRateLimitExceeded(ctx, "ip", false)
+	limiter.IsRateLimitExceeded(ctx, "ip", false)
+
+	assert.Equal(t, []bool{false, true}, metrics.decisions)
+}
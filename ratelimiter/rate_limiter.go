@@ -2,37 +2,73 @@ package ratelimiter
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
-	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/jpodlasnisky/ratelimiter/infra/database/contract_db"
-	"github.com/go-redis/redis/v8"
 )
 
 type RateLimiter struct {
-	Database               interface{ contract_db.Datastore }
-	ConfigToken            map[string]int64
-	lockDurationSeconds    int64
-	blockDurationSeconds   int64
-	ipMaxRequestsPerSecond int64
+	Store         contract_db.LimiterStore
+	ConfigToken   map[string]int64
+	window        time.Duration
+	blockDuration time.Duration
+	ipLimit       int64
+	algorithm     Algorithm
+	failMode      FailMode
+	keyPrefix     string
+	keyHashSecret []byte
+	blockCache    *blockCache
+	clock         Clock
+	logger        Logger
+	metrics       Metrics
+	hitSequence   atomic.Uint64
+
+	tenants            map[string]Tenant
+	organizationLimits map[string]int64
+	projectLimits      map[string]int64
+
+	tokenSchedules map[string]TokenSchedule
+	tokenRotations map[string]string
 }
 
-func NewLimiter(db contract_db.Datastore, configToken map[string]int64, lockDurationSeconds, blockDurationSeconds, ipMaxRequestsPerSecond int64) *RateLimiter {
+// New builds a limiter over the store. Without options it uses a sliding
+// window of one second, blocks for a minute and allows 10 requests per IP.
+func New(store contract_db.LimiterStore, opts ...Option) *RateLimiter {
 	limiter := &RateLimiter{
-		Database:               db,
-		ConfigToken:            configToken,
-		lockDurationSeconds:    lockDurationSeconds,
-		blockDurationSeconds:   blockDurationSeconds,
-		ipMaxRequestsPerSecond: ipMaxRequestsPerSecond,
+		Store:         store,
+		ConfigToken:   map[string]int64{},
+		window:        defaultWindow,
+		blockDuration: defaultBlockDuration,
+		ipLimit:       defaultIPLimit,
+		algorithm:     SlidingWindow,
+		failMode:      FailClosed,
+		blockCache:    newBlockCache(defaultBlockCacheSize),
+		clock:         SystemClock(),
+		logger:        log.Default(),
+		metrics:       nopMetrics{},
+	}
+
+	for _, opt := range opts {
+		opt(limiter)
 	}
 	return limiter
 }
 
+// NewLimiter is kept for the callers of the positional constructor; prefer New.
+func NewLimiter(db contract_db.Datastore, configToken map[string]int64, lockDurationSeconds, blockDurationSeconds, ipMaxRequestsPerSecond int64) *RateLimiter {
+	return New(contract_db.NewDatastoreStore(db),
+		WithPolicies(configToken),
+		WithWindow(time.Duration(lockDurationSeconds)*time.Second),
+		WithBlockDuration(time.Duration(blockDurationSeconds)*time.Second),
+		WithIPLimit(ipMaxRequestsPerSecond),
+	)
+}
+
 func (l *RateLimiter) CheckRateLimitForKey(ctx context.Context, key string, isToken bool) (bool, error) {
 
 	type result struct {
@@ -65,7 +101,7 @@ func (l *RateLimiter) CheckRateLimitForKey(ctx context.Context, key string, isTo
 
 	for r := range results {
 		if r.Err != nil {
-			log.Printf("Error checking rate limit for key %s: %v", r.Key, r.Err)
+			l.logger.Printf("Error checking rate limit for key %s: %v", l.logKey(r.Key), r.Err)
 			err = r.Err
 		} else if r.Blocked {
 			isBlocked = true
@@ -76,6 +112,38 @@ func (l *RateLimiter) CheckRateLimitForKey(ctx context.Context, key string, isTo
 }
 
 func (l *RateLimiter) IsRateLimitExceeded(ctx context.Context, key string, isToken bool) (bool, error) {
+	if isToken {
+		bucket, err := l.tokenBucket(key)
+		if err != nil {
+			return false, err
+		}
+		key = bucket
+	}
+
+	exceeded, err := l.isRateLimitExceeded(ctx, key, isToken)
+	if err != nil {
+		return false, l.handleStoreError(key, err)
+	}
+
+	l.metrics.RecordDecision(key, isToken, exceeded)
+	return exceeded, nil
+}
+
+// handleStoreError records the error and swallows it when the limiter fails open.
+func (l *RateLimiter) handleStoreError(key string, err error) error {
+	if isScheduleError(err) || errors.Is(err, ErrNegativeHits) {
+		return err
+	}
+
+	l.metrics.RecordError(key, err)
+	if l.failMode == FailOpen {
+		l.logger.Printf("Allowing key %s after a store error (fail open): %v", l.logKey(key), err)
+		return nil
+	}
+	return err
+}
+
+func (l *RateLimiter) isRateLimitExceeded(ctx context.Context, key string, isToken bool) (bool, error) {
 	isBlocked, err := l.IsKeyBlocked(ctx, key)
 	if err != nil {
 		return false, err
@@ -85,83 +153,180 @@ func (l *RateLimiter) IsRateLimitExceeded(ctx context.Context, key string, isTok
 		return true, nil
 	}
 
-	redisKey := "limiter:" + key
+	now := l.clock.Now()
+	storeKey := l.storeKey(key)
 
-	now := time.Now().Unix() // Obtém o tempo agora em segundos desde a epoch
-	minScore := "-inf"
-
-	// Remova os membros do conjunto cujo score é menor que o tempo agora
-	_, err = l.Database.ZRemRangeByScore(ctx, redisKey, minScore, strconv.FormatInt(now, 10))
-	if err != nil && err != redis.Nil {
+	// Descarta os hits que já saíram da janela e conta os restantes
+	count, err := l.Store.CountInWindow(ctx, storeKey, now)
+	if err != nil {
 		return false, err
 	}
 
-	// Verifique o número de membros restantes no conjunto
-	count, err := l.Database.ZCard(ctx, redisKey)
-	if err != nil && err != redis.Nil {
+	reqRateLimit, err := l.limitFor(ctx, storeKey, isToken)
+	if err != nil {
 		return false, err
 	}
 
-	var reqRateLimit int
-
-	if isToken {
+	// Um token de um tenant também conta no projeto e na organização
+	levels := l.levelsFor(key, reqRateLimit, isToken)
+	counts := []int64{count}
+	for _, lvl := range levels[1:] {
+		if isBlocked, err := l.IsKeyBlocked(ctx, lvl.key); err != nil || isBlocked {
+			return isBlocked, err
+		}
 
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		defer cancel()
+		levelCount, err := l.Store.CountInWindow(ctx, l.storeKey(lvl.key), now)
+		if err != nil {
+			return false, err
+		}
+		counts = append(counts, levelCount)
+	}
 
-		tokenConfigStr, err := l.Database.Get(ctx, key)
-		if err == redis.Nil {
-			return false, errors.New("token não encontrado")
+	for i, lvl := range levels {
+		if counts[i] >= lvl.limit && i > 0 {
+			// O projeto e a organização são compartilhados: só recusa até a
+			// janela liberar espaço, sem bloquear os outros tokens
+			l.logger.Printf("key full: %s count: %d, reqLimit: %d \n", l.logKey(lvl.key), counts[i], lvl.limit)
+			return true, nil
 		}
+		if counts[i] >= lvl.limit {
+			if err = l.BlockKey(ctx, lvl.key); err != nil {
+				return false, err
+			}
+			l.logger.Printf("key blocked: %s count: %d, reqLimit: %d \n", l.logKey(lvl.key), counts[i], lvl.limit)
 
-		type TokenConfig struct {
-			Token    string `json:"token"`
-			LimitReq int64  `json:"limitReq"`
+			return true, nil
 		}
+	}
+
+	for i, lvl := range levels {
+		l.logger.Printf("key: %s count: %d, reqLimit: %d \n", l.logKey(lvl.key), counts[i]+1, lvl.limit)
 
-		var tokenConfig TokenConfig
-		if err = json.Unmarshal([]byte(tokenConfigStr), &tokenConfig); err != nil {
+		if err := l.Store.RecordHit(ctx, l.storeKey(lvl.key), l.newHitID(now), l.hitExpiry(now), now); err != nil {
 			return false, err
 		}
-		reqRateLimit = int(tokenConfig.LimitReq)
+	}
+
+	return false, nil
+}
 
-	} else {
-		reqRateLimit = int(l.ipMaxRequestsPerSecond)
+// limitFor returns how many requests the key may make per window: the policy
+// stored for a token or the IP limit otherwise.
+func (l *RateLimiter) limitFor(ctx context.Context, storeKey string, isToken bool) (int64, error) {
+	if !isToken {
+		return l.ipLimit, nil
 	}
 
-	if count < int64(reqRateLimit) {
-		log.Printf("key: %s count: %d, reqLimit: %d \n", key, count+1, reqRateLimit)
-		expireTime := now + int64(l.lockDurationSeconds)
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
 
-		_, err := l.Database.ZAdd(ctx, redisKey, &redis.Z{
-			Score:  float64(expireTime),
-			Member: time.Now().Format(time.RFC3339Nano),
-		})
-		if err != nil {
-			return false, err
-		}
+	policy, err := l.Store.GetPolicy(ctx, storeKey)
+	if errors.Is(err, contract_db.ErrNotFound) {
+		return 0, errors.New("token não encontrado")
+	}
+	if err != nil {
+		return 0, err
+	}
+	return policy.LimitReq, nil
+}
+
+// newHitID names a hit after the current time. The sequence suffix keeps two
+// hits made at the same instant from becoming a single member.
+func (l *RateLimiter) newHitID(now time.Time) string {
+	return fmt.Sprintf("%s-%d", now.Format(time.RFC3339Nano), l.hitSequence.Add(1))
+}
 
-		return false, nil
+// newHitIDs names the n hits of a single request made now.
+func (l *RateLimiter) newHitIDs(now time.Time, n int) []string {
+	hitIDs := make([]string, n)
+	for i := range hitIDs {
+		hitIDs[i] = l.newHitID(now)
 	}
+	return hitIDs
+}
 
-	if err = l.BlockKey(ctx, key); err != nil {
-		return false, err
+// hitExpiry returns until when a hit made now is counted. In the sliding
+// window each hit lives for a full window; in the fixed window every hit
+// expires together at the end of the current window.
+func (l *RateLimiter) hitExpiry(now time.Time) time.Time {
+	return l.hitExpiryIn(now, l.window)
+}
+
+func (l *RateLimiter) hitExpiryIn(now time.Time, window time.Duration) time.Time {
+	if l.algorithm == FixedWindow && window > 0 {
+		return now.Truncate(window).Add(window)
 	}
-	log.Printf("key blocked: %s count: %d, reqLimit: %d \n", key, count, reqRateLimit)
+	return now.Add(window)
+}
 
-	return true, nil
+func (l *RateLimiter) storeKey(key string) string {
+	return l.keyPrefix + l.hashKey(key)
 }
 
 func (l *RateLimiter) BlockKey(ctx context.Context, key string) error {
-	return l.Database.SetEX(ctx, "block:"+key, "", time.Second*time.Duration(l.blockDurationSeconds))
+	return l.BlockKeyFor(ctx, key, l.blockDuration)
+}
+
+// BlockKeyFor blocks the key for the given duration instead of the configured
+// block duration, e.g. for as long as an upstream asked us to back off.
+func (l *RateLimiter) BlockKeyFor(ctx context.Context, key string, duration time.Duration) error {
+	storeKey := l.storeKey(key)
+	if err := l.Store.Block(ctx, storeKey, duration); err != nil {
+		return err
+	}
+
+	now := l.clock.Now()
+	l.blockCache.add(storeKey, now.Add(duration), now)
+	return nil
 }
 
 func (l *RateLimiter) IsKeyBlocked(ctx context.Context, key string) (bool, error) {
-	exists, err := l.Database.Exists(ctx, "block:"+key)
+	_, isBlocked, err := l.blockedUntil(ctx, key)
+	return isBlocked, err
+}
+
+// blockedUntil tells whether the key is blocked and until when. When the
+// store can't tell how long the block lasts, a full block duration is assumed.
+func (l *RateLimiter) blockedUntil(ctx context.Context, key string) (time.Time, bool, error) {
+	storeKey := l.storeKey(key)
+	now := l.clock.Now()
+	if until, isBlocked := l.blockCache.blockedUntil(storeKey, now); isBlocked {
+		return until, true, nil
+	}
+
+	isBlocked, remaining, err := l.Store.IsBlocked(ctx, storeKey)
+	if err != nil || !isBlocked {
+		return time.Time{}, false, err
+	}
+
+	// Guarda o bloqueio localmente até o fim do TTL definido no Datastore.
+	if remaining > 0 {
+		l.blockCache.add(storeKey, now.Add(remaining), now)
+		return now.Add(remaining), true, nil
+	}
+	return now.Add(l.blockDuration), true, nil
+}
+
+// UnblockKey clears the block of a key and tells the other instances to drop
+// it from their local cache.
+func (l *RateLimiter) UnblockKey(ctx context.Context, key string) error {
+	storeKey := l.storeKey(key)
+	l.blockCache.remove(storeKey)
+	return l.Store.Unblock(ctx, storeKey)
+}
+
+// WatchBlockInvalidations removes from the local cache every key unblocked by
+// any instance. It runs until the context is done.
+func (l *RateLimiter) WatchBlockInvalidations(ctx context.Context) error {
+	keys, err := l.Store.WatchUnblocks(ctx)
 	if err != nil {
-		return false, err
+		return err
 	}
-	return exists == 1, nil
+
+	for key := range keys {
+		l.blockCache.remove(key)
+	}
+	return ctx.Err()
 }
 
 func (r *RateLimiter) TokenExists(token string) bool {
@@ -173,29 +338,35 @@ func (l *RateLimiter) RegisterPersonalizedTokens(ctx context.Context) error {
 
 	for token, limitReq := range l.ConfigToken {
 
-		data := struct {
-			Token    string `json:"token"`
-			LimitReq int64  `json:"limitReq"`
-		}{
+		ttl, valid := l.policyTTL(token)
+		if !valid {
+			// Apaga a política gravada sem TTL por versões anteriores.
+			if err := l.Store.Purge(ctx, l.storeKey(token)); err != nil {
+				return err
+			}
+			l.logger.Printf("token expired, not registered: %s", l.logKey(token))
+			continue
+		}
+
+		policy := contract_db.Policy{
 			Token:    token,
 			LimitReq: limitReq,
 		}
-
-		jsonData, err := json.Marshal(data)
-		if err != nil {
-			return err
+		if len(l.keyHashSecret) > 0 {
+			// Com as chaves em hash, o token não é gravado nem no valor.
+			policy.Token = ""
 		}
 
-		if err = l.Database.Set(ctx, token, jsonData, 0); err != nil {
+		if err := l.Store.PutPolicy(ctx, l.storeKey(token), policy, ttl); err != nil {
 			return err
 		}
 
-		storedValue, err := l.Database.Get(ctx, token)
+		storedValue, err := l.Store.GetPolicy(ctx, l.storeKey(token))
 		if err != nil {
 			return err
 		}
 
-		fmt.Println("storedValue: ", storedValue)
+		l.logger.Printf("storedValue: %+v", storedValue)
 	}
 	return nil
 }
@@ -8,6 +8,7 @@ import (
 	"time"
 
 	"github.com/jpodlasnisky/ratelimiter/infra/database"
+	"github.com/jpodlasnisky/ratelimiter/infra/database/contract_db"
 	"github.com/go-redis/redis/v8"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
@@ -18,11 +19,12 @@ func TestCheckRateLimitForKey_NotExceeded(t *testing.T) {
 	mockRedis := new(database.MockRedisClient)
 	db := NewLimiter(mockRedis, map[string]int64{"test_token": 2}, 1, 5, 3)
 
-	mockRedis.On("Exists", ctx, []string{"block:test_token"}).Return(int64(0), nil)
-	mockRedis.On("ZRemRangeByScore", ctx, "limiter:test_token", "-inf", mock.Anything).Return(int64(0), nil)
-	mockRedis.On("ZCard", ctx, "limiter:test_token").Return(int64(1), nil)
+	mockRedis.On("Exists", ctx, []string{"block:{test_token}"}).Return(int64(0), nil)
+	mockRedis.On("ZRemRangeByScore", ctx, "limiter:{test_token}", "-inf", mock.Anything).Return(int64(0), nil)
+	mockRedis.On("ZCard", ctx, "limiter:{test_token}").Return(int64(1), nil)
 	mockRedis.On("Get", mock.AnythingOfType("*context.timerCtx"), "test_token").Return(`{"token":"test_token","limitReq":2}`, nil)
-	mockRedis.On("ZAdd", ctx, "limiter:test_token", mock.Anything).Return(int64(1), nil)
+	mockRedis.On("ZAdd", ctx, "limiter:{test_token}", mock.Anything).Return(int64(1), nil)
+	mockRedis.On("ExtendTTL", ctx, "limiter:{test_token}", mock.Anything).Return(nil)
 
 	exceeded, err := db.CheckRateLimitForKey(ctx, "test_token", true)
 	assert.NoError(t, err)
@@ -37,11 +39,11 @@ func TestCheckRateLimitForKey_Exceeded(t *testing.T) {
 	db := NewLimiter(mockRedis, map[string]int64{"test_token": 2}, 1, 5, 3)
 
 	mockRedis.On("Exists", ctx, mock.AnythingOfType("[]string")).Return(int64(0), nil)
-	mockRedis.On("ZRemRangeByScore", ctx, "limiter:test_token", "-inf", mock.AnythingOfType("string")).Return(int64(0), nil)
-	mockRedis.On("ZCard", ctx, "limiter:test_token").Return(int64(3), nil)
+	mockRedis.On("ZRemRangeByScore", ctx, "limiter:{test_token}", "-inf", mock.AnythingOfType("string")).Return(int64(0), nil)
+	mockRedis.On("ZCard", ctx, "limiter:{test_token}").Return(int64(3), nil)
 	mockRedis.On("Get", mock.AnythingOfType("*context.timerCtx"), "test_token").Return(`{"token":"test_token","limitReq":2}`, nil)
 
-	mockRedis.On("SetEX", ctx, "block:test_token", "", time.Duration(5)*time.Second).Return(nil)
+	mockRedis.On("SetEX", ctx, "block:{test_token}", "", time.Duration(5)*time.Second).Return(nil)
 
 	exceeded, err := db.CheckRateLimitForKey(ctx, "test_token", true)
 	assert.NoError(t, err)
@@ -55,11 +57,11 @@ func TestIsRateLimitExceeded(t *testing.T) {
 	mockRedis := new(database.MockRedisClient)
 	db := NewLimiter(mockRedis, map[string]int64{"test_token": 2}, 1, 5, 3)
 
-	mockRedis.On("Exists", ctx, []string{"block:test_token"}).Return(int64(0), nil)
-	mockRedis.On("ZRemRangeByScore", ctx, "limiter:test_token", "-inf", mock.Anything).Return(int64(0), nil)
-	mockRedis.On("ZCard", ctx, "limiter:test_token").Return(int64(2), nil)
+	mockRedis.On("Exists", ctx, []string{"block:{test_token}"}).Return(int64(0), nil)
+	mockRedis.On("ZRemRangeByScore", ctx, "limiter:{test_token}", "-inf", mock.Anything).Return(int64(0), nil)
+	mockRedis.On("ZCard", ctx, "limiter:{test_token}").Return(int64(2), nil)
 	mockRedis.On("Get", mock.AnythingOfType("*context.timerCtx"), "test_token").Return(`{"token":"test_token","limitReq":2}`, nil)
-	mockRedis.On("SetEX", ctx, "block:test_token", "", time.Duration(5*time.Second)).Return(nil)
+	mockRedis.On("SetEX", ctx, "block:{test_token}", "", time.Duration(5*time.Second)).Return(nil)
 
 	exceeded, err := db.IsRateLimitExceeded(ctx, "test_token", true)
 	assert.NoError(t, err)
@@ -72,7 +74,7 @@ func TestIsRateLimitExceeded_WhenIsKeyBlockedReturnsError(t *testing.T) {
 	mockRedis := new(database.MockRedisClient)
 	db := NewLimiter(mockRedis, map[string]int64{"test_token": 2}, 1, 5, 3)
 
-	mockRedis.On("Exists", ctx, []string{"block:test_token"}).Return(int64(0), errors.New("mock error"))
+	mockRedis.On("Exists", ctx, []string{"block:{test_token}"}).Return(int64(0), errors.New("mock error"))
 	_, err := db.IsRateLimitExceeded(ctx, "test_token", true)
 	assert.Error(t, err, "Expected error when IsKeyBlocked returns an error")
 	mockRedis.AssertExpectations(t)
@@ -83,8 +85,8 @@ func TestIsRateLimitExceeded_WhenZRemRangeByScoreReturnsError(t *testing.T) {
 	mockRedis := new(database.MockRedisClient)
 	db := NewLimiter(mockRedis, map[string]int64{"test_token": 2}, 1, 5, 3)
 
-	mockRedis.On("Exists", ctx, []string{"block:test_token"}).Return(int64(0), nil)
-	mockRedis.On("ZRemRangeByScore", ctx, "limiter:test_token", "-inf", mock.Anything).Return(int64(0), errors.New("mock error"))
+	mockRedis.On("Exists", ctx, []string{"block:{test_token}"}).Return(int64(0), nil)
+	mockRedis.On("ZRemRangeByScore", ctx, "limiter:{test_token}", "-inf", mock.Anything).Return(int64(0), errors.New("mock error"))
 	_, err := db.IsRateLimitExceeded(ctx, "test_token", true)
 	assert.Error(t, err, "Expected error when ZRemRangeByScore returns an error")
 	mockRedis.AssertExpectations(t)
@@ -95,9 +97,9 @@ func TestIsRateLimitExceeded_WhenZCardReturnsError(t *testing.T) {
 	mockRedis := new(database.MockRedisClient)
 	db := NewLimiter(mockRedis, map[string]int64{"test_token": 2}, 1, 5, 3)
 
-	mockRedis.On("Exists", ctx, []string{"block:test_token"}).Return(int64(0), nil)
-	mockRedis.On("ZRemRangeByScore", ctx, "limiter:test_token", "-inf", mock.Anything).Return(int64(0), nil)
-	mockRedis.On("ZCard", ctx, "limiter:test_token").Return(int64(0), errors.New("mock error"))
+	mockRedis.On("Exists", ctx, []string{"block:{test_token}"}).Return(int64(0), nil)
+	mockRedis.On("ZRemRangeByScore", ctx, "limiter:{test_token}", "-inf", mock.Anything).Return(int64(0), nil)
+	mockRedis.On("ZCard", ctx, "limiter:{test_token}").Return(int64(0), errors.New("mock error"))
 	_, err := db.IsRateLimitExceeded(ctx, "test_token", true)
 	assert.Error(t, err, "Expected error when ZCard returns an error")
 	mockRedis.AssertExpectations(t)
@@ -108,9 +110,9 @@ func TestIsRateLimitExceeded_WhenGetReturnsRedisNil(t *testing.T) {
 	mockRedis := new(database.MockRedisClient)
 	db := NewLimiter(mockRedis, map[string]int64{"test_token": 2}, 1, 5, 3)
 
-	mockRedis.On("ZCard", ctx, "limiter:test_token").Return(int64(2), nil).Once()
-	mockRedis.On("Exists", ctx, []string{"block:test_token"}).Return(int64(0), nil)
-	mockRedis.On("ZRemRangeByScore", ctx, "limiter:test_token", "-inf", mock.Anything).Return(int64(0), nil)
+	mockRedis.On("ZCard", ctx, "limiter:{test_token}").Return(int64(2), nil).Once()
+	mockRedis.On("Exists", ctx, []string{"block:{test_token}"}).Return(int64(0), nil)
+	mockRedis.On("ZRemRangeByScore", ctx, "limiter:{test_token}", "-inf", mock.Anything).Return(int64(0), nil)
 	mockRedis.On("Get", mock.AnythingOfType("*context.timerCtx"), "test_token").Return("", redis.Nil)
 	_, err := db.IsRateLimitExceeded(ctx, "test_token", true)
 	assert.Error(t, err, "Expected error when Get returns redis.Nil")
@@ -121,9 +123,9 @@ func TestIsRateLimitExceeded_WhenJsonUnmarshalReturnsError(t *testing.T) {
 	mockRedis := new(database.MockRedisClient)
 	db := NewLimiter(mockRedis, map[string]int64{"test_token": 2}, 1, 5, 3)
 
-	mockRedis.On("Exists", ctx, []string{"block:test_token"}).Return(int64(0), nil)
-	mockRedis.On("ZRemRangeByScore", ctx, "limiter:test_token", "-inf", mock.Anything).Return(int64(0), nil)
-	mockRedis.On("ZCard", ctx, "limiter:test_token").Return(int64(0), nil)
+	mockRedis.On("Exists", ctx, []string{"block:{test_token}"}).Return(int64(0), nil)
+	mockRedis.On("ZRemRangeByScore", ctx, "limiter:{test_token}", "-inf", mock.Anything).Return(int64(0), nil)
+	mockRedis.On("ZCard", ctx, "limiter:{test_token}").Return(int64(0), nil)
 	mockRedis.On("Get", mock.AnythingOfType("*context.timerCtx"), "test_token").Return("invalid json", nil)
 	_, err := db.IsRateLimitExceeded(ctx, "test_token", true)
 	assert.Error(t, err, "Expected error when json.Unmarshal returns an error")
@@ -135,12 +137,12 @@ func TestIsRateLimitExceeded_WhenBlockKeyReturnsError(t *testing.T) {
 	mockRedis := new(database.MockRedisClient)
 	db := NewLimiter(mockRedis, map[string]int64{"test_token": 2}, 1, 5, 3)
 
-	mockRedis.On("Exists", ctx, []string{"block:test_token"}).Return(int64(0), nil)
-	mockRedis.On("ZRemRangeByScore", ctx, "limiter:test_token", "-inf", mock.Anything).Return(int64(0), nil)
-	mockRedis.On("ZCard", ctx, "limiter:test_token").Return(int64(2), nil)
+	mockRedis.On("Exists", ctx, []string{"block:{test_token}"}).Return(int64(0), nil)
+	mockRedis.On("ZRemRangeByScore", ctx, "limiter:{test_token}", "-inf", mock.Anything).Return(int64(0), nil)
+	mockRedis.On("ZCard", ctx, "limiter:{test_token}").Return(int64(2), nil)
 
 	mockRedis.On("Get", mock.AnythingOfType("*context.timerCtx"), "test_token").Return(`{"token":"test_token","limitReq":2}`, nil)
-	mockRedis.On("SetEX", ctx, "block:test_token", "", time.Duration(5*time.Second)).Return(errors.New("mock error"))
+	mockRedis.On("SetEX", ctx, "block:{test_token}", "", time.Duration(5*time.Second)).Return(errors.New("mock error"))
 
 	db.IsRateLimitExceeded(ctx, "test_token", true)
 	_, err := db.IsRateLimitExceeded(ctx, "test_token", true)
@@ -153,8 +155,8 @@ func TestCheckRateLimitForKey_Error(t *testing.T) {
 	mockRedis := new(database.MockRedisClient)
 	db := NewLimiter(mockRedis, map[string]int64{"test_token": 2}, 1, 5, 3)
 
-	mockRedis.On("Exists", ctx, []string{"block:test_token"}).Return(int64(0), nil)
-	mockRedis.On("ZRemRangeByScore", ctx, "limiter:test_token", "-inf", mock.Anything).Return(int64(0), errors.New("redis error"))
+	mockRedis.On("Exists", ctx, []string{"block:{test_token}"}).Return(int64(0), nil)
+	mockRedis.On("ZRemRangeByScore", ctx, "limiter:{test_token}", "-inf", mock.Anything).Return(int64(0), errors.New("redis error"))
 
 	exceeded, err := db.CheckRateLimitForKey(ctx, "test_token", true)
 	assert.Error(t, err)
@@ -168,7 +170,7 @@ func TestBlockKey(t *testing.T) {
 	db := NewLimiter(mockRedis, nil, 60, 60, 10)
 	ctx := context.Background()
 
-	mockRedis.On("SetEX", ctx, "block:test_key", "", time.Minute).Return(nil)
+	mockRedis.On("SetEX", ctx, "block:{test_key}", "", time.Minute).Return(nil)
 
 	err := db.BlockKey(ctx, "test_key")
 	assert.NoError(t, err)
@@ -179,13 +181,103 @@ func TestIsKeyBlocked_Blocked(t *testing.T) {
 	db := NewLimiter(mockRedis, nil, 60, 60, 10)
 	ctx := context.Background()
 
-	mockRedis.On("Exists", ctx, []string{"block:test_key"}).Return(int64(1), nil)
+	mockRedis.On("Exists", ctx, []string{"block:{test_key}"}).Return(int64(1), nil)
+	mockRedis.On("TTL", ctx, "block:{test_key}").Return(time.Minute, nil)
 
 	blocked, err := db.IsKeyBlocked(ctx, "test_key")
 	assert.NoError(t, err)
 	assert.True(t, blocked)
 }
 
+func TestIsKeyBlocked_ServedFromLocalCache(t *testing.T) {
+	mockRedis := new(database.MockRedisClient)
+	db := NewLimiter(mockRedis, nil, 60, 60, 10)
+	ctx := context.Background()
+
+	mockRedis.On("Exists", ctx, []string{"block:{test_key}"}).Return(int64(1), nil).Once()
+	mockRedis.On("TTL", ctx, "block:{test_key}").Return(time.Minute, nil).Once()
+
+	for i := 0; i < 3; i++ {
+		blocked, err := db.IsKeyBlocked(ctx, "test_key")
+		assert.NoError(t, err)
+		assert.True(t, blocked)
+	}
+	mockRedis.AssertExpectations(t)
+}
+
+func TestIsKeyBlocked_AfterBlockKeySkipsDatastore(t *testing.T) {
+	mockRedis := new(database.MockRedisClient)
+	db := NewLimiter(mockRedis, nil, 60, 60, 10)
+	ctx := context.Background()
+
+	mockRedis.On("SetEX", ctx, "block:{test_key}", "", time.Minute).Return(nil)
+
+	assert.NoError(t, db.BlockKey(ctx, "test_key"))
+
+	blocked, err := db.IsKeyBlocked(ctx, "test_key")
+	assert.NoError(t, err)
+	assert.True(t, blocked)
+	mockRedis.AssertNotCalled(t, "Exists", mock.Anything, mock.Anything)
+}
+
+func TestIsKeyBlocked_CacheDisabled(t *testing.T) {
+	mockRedis := new(database.MockRedisClient)
+	db := New(contract_db.NewDatastoreStore(mockRedis),
+		WithWindow(time.Minute),
+		WithBlockDuration(time.Minute),
+		WithIPLimit(10),
+		WithBlockCacheSize(0),
+	)
+	ctx := context.Background()
+
+	mockRedis.On("SetEX", ctx, "block:{test_key}", "", time.Minute).Return(nil)
+	mockRedis.On("Exists", ctx, []string{"block:{test_key}"}).Return(int64(0), nil)
+
+	assert.NoError(t, db.BlockKey(ctx, "test_key"))
+
+	blocked, err := db.IsKeyBlocked(ctx, "test_key")
+	assert.NoError(t, err)
+	assert.False(t, blocked)
+	mockRedis.AssertExpectations(t)
+}
+
+func TestUnblockKey(t *testing.T) {
+	mockRedis := new(database.MockRedisClient)
+	db := NewLimiter(mockRedis, nil, 60, 60, 10)
+	ctx := context.Background()
+
+	mockRedis.On("SetEX", ctx, "block:{test_key}", "", time.Minute).Return(nil)
+	mockRedis.On("Del", ctx, []string{"block:{test_key}"}).Return(int64(1), nil)
+	mockRedis.On("Publish", ctx, "block:invalidate", "test_key").Return(nil)
+	mockRedis.On("Exists", ctx, []string{"block:{test_key}"}).Return(int64(0), nil)
+
+	assert.NoError(t, db.BlockKey(ctx, "test_key"))
+	assert.NoError(t, db.UnblockKey(ctx, "test_key"))
+
+	blocked, err := db.IsKeyBlocked(ctx, "test_key")
+	assert.NoError(t, err)
+	assert.False(t, blocked)
+	mockRedis.AssertExpectations(t)
+}
+
+func TestWatchBlockInvalidations(t *testing.T) {
+	mockRedis := new(database.MockRedisClient)
+	db := NewLimiter(mockRedis, nil, 60, 60, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	messages := make(chan string, 1)
+	mockRedis.On("Subscribe", ctx, "block:invalidate").Return(messages, nil)
+
+	now := time.Now()
+	db.blockCache.add("test_key", now.Add(time.Minute), now)
+	messages <- "test_key"
+	close(messages)
+
+	assert.NoError(t, db.WatchBlockInvalidations(ctx))
+	assert.False(t, db.blockCache.isBlocked("test_key", now))
+}
+
 func TestTokenExists(t *testing.T) {
 	mockRedis := new(database.MockRedisClient)
 	db := NewLimiter(mockRedis, map[string]int64{"existing_token": 5}, 1, 5, 3)
@@ -0,0 +1,131 @@
+package ratelimiter
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrTokenNotYetValid is returned for a token used before its NotBefore.
+	ErrTokenNotYetValid = errors.New("token not valid yet")
+
+	// ErrTokenExpired is returned for a token used after its ExpiresAt.
+	ErrTokenExpired = errors.New("token expired")
+)
+
+// TokenSchedule is when a token may be used. A zero time leaves that side open.
+type TokenSchedule struct {
+	NotBefore time.Time
+	ExpiresAt time.Time
+}
+
+// ParseTokenSchedule reads a schedule written as "notBefore/expiresAt", both
+// RFC 3339 and either one empty, e.g. "/2025-01-01T00:00:00Z".
+func ParseTokenSchedule(value string) (TokenSchedule, error) {
+	notBefore, expiresAt, found := strings.Cut(value, "/")
+	if !found {
+		return TokenSchedule{}, fmt.Errorf("invalid token schedule %q: missing /", value)
+	}
+
+	var schedule TokenSchedule
+	var err error
+	if notBefore = strings.TrimSpace(notBefore); notBefore != "" {
+		if schedule.NotBefore, err = time.Parse(time.RFC3339, notBefore); err != nil {
+			return TokenSchedule{}, fmt.Errorf("invalid token schedule %q: %w", value, err)
+		}
+	}
+	if expiresAt = strings.TrimSpace(expiresAt); expiresAt != "" {
+		if schedule.ExpiresAt, err = time.Parse(time.RFC3339, expiresAt); err != nil {
+			return TokenSchedule{}, fmt.Errorf("invalid token schedule %q: %w", value, err)
+		}
+	}
+	return schedule, nil
+}
+
+// check returns the reason the token can't be used at now, if any.
+func (s TokenSchedule) check(now time.Time) error {
+	if !s.NotBefore.IsZero() && now.Before(s.NotBefore) {
+		return ErrTokenNotYetValid
+	}
+	if !s.ExpiresAt.IsZero() && !now.Before(s.ExpiresAt) {
+		return ErrTokenExpired
+	}
+	return nil
+}
+
+// WithTokenSchedules sets when each token may be used. The policy of a token
+// with ExpiresAt is stored with a TTL until then.
+func WithTokenSchedules(schedules map[string]TokenSchedule) Option {
+	return func(l *RateLimiter) {
+		l.tokenSchedules = schedules
+	}
+}
+
+// WithTokenRotations maps each new token to the token it replaces. While the
+// old token is still valid, the new one is counted in the window and limit
+// of the old one, so both share a single quota during the overlap.
+func WithTokenRotations(rotations map[string]string) Option {
+	return func(l *RateLimiter) {
+		l.tokenRotations = rotations
+	}
+}
+
+// CheckTokenSchedule returns ErrTokenNotYetValid or ErrTokenExpired when the
+// token can't be used now. Tokens without a schedule are always valid.
+func (l *RateLimiter) CheckTokenSchedule(token string) error {
+	schedule, exists := l.tokenSchedules[token]
+	if !exists {
+		return nil
+	}
+	return schedule.check(l.clock.Now())
+}
+
+// bucketFor returns the token whose window and limit the token is counted
+// in: the replaced token during a rotation, or the token itself.
+func (l *RateLimiter) bucketFor(token string) string {
+	replaced, exists := l.tokenRotations[token]
+	if !exists || !l.TokenExists(replaced) || l.CheckTokenSchedule(replaced) != nil {
+		return token
+	}
+	return replaced
+}
+
+// tokenBucket checks the schedule of the token and returns the token it is
+// counted as, so every entry point applies the same validity and rotation.
+func (l *RateLimiter) tokenBucket(token string) (string, error) {
+	if err := l.CheckTokenSchedule(token); err != nil {
+		return "", err
+	}
+	return l.bucketFor(token), nil
+}
+
+// TokenKey builds the key of the kind counted for the token and the parts,
+// e.g. the limit of a gRPC method per token. It checks the schedule of the
+// token and uses the token it is counted as, like the limit of the token.
+func (l *RateLimiter) TokenKey(kind KeyKind, token string, parts ...string) (string, error) {
+	bucket, err := l.tokenBucket(token)
+	if err != nil {
+		return "", err
+	}
+	return kind.Key(append([]string{bucket}, parts...)...), nil
+}
+
+// isScheduleError reports whether err says the token can't be used now. It is
+// a verdict about the caller, not a store failure, so it is never failed open.
+func isScheduleError(err error) bool {
+	return errors.Is(err, ErrTokenExpired) || errors.Is(err, ErrTokenNotYetValid)
+}
+
+// policyTTL returns how long the policy of the token is kept: until its
+// expiry, or forever. It returns false when the token already expired.
+func (l *RateLimiter) policyTTL(token string) (time.Duration, bool) {
+	schedule, exists := l.tokenSchedules[token]
+	if !exists || schedule.ExpiresAt.IsZero() {
+		return 0, true
+	}
+
+	ttl := schedule.ExpiresAt.Sub(l.clock.Now())
+	return ttl, ttl > 0
+}
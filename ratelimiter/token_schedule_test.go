@@ -0,0 +1,139 @@
+package ratelimiter
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseTokenSchedule(t *testing.T) {
+	schedule, err := ParseTokenSchedule("2024-01-01T00:00:00Z/2024-02-01T00:00:00Z")
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), schedule.NotBefore.UTC())
+	assert.Equal(t, time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC), schedule.ExpiresAt.UTC())
+
+	schedule, err = ParseTokenSchedule("/2024-02-01T00:00:00Z")
+	assert.NoError(t, err)
+	assert.True(t, schedule.NotBefore.IsZero())
+
+	for _, value := range []string{"2024-01-01T00:00:00Z", "yesterday/", "/2024-02-01"} {
+		_, err := ParseTokenSchedule(value)
+		assert.Error(t, err, value)
+	}
+}
+
+func newScheduledLimiter(t *testing.T) (*RateLimiter, *FakeClock, *memoryStore) {
+	clock := NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	store := newMemoryStore(clock)
+	limiter := New(store,
+		WithClock(clock),
+		WithPolicies(map[string]int64{"old": 3, "new": 10, "future": 5, "expired": 5}),
+		WithTokenSchedules(map[string]TokenSchedule{
+			"old":     {ExpiresAt: clock.Now().Add(time.Hour)},
+			"future":  {NotBefore: clock.Now().Add(time.Hour)},
+			"expired": {ExpiresAt: clock.Now().Add(-time.Hour)},
+		}),
+		WithTokenRotations(map[string]string{"new": "old"}),
+	)
+	assert.NoError(t, limiter.RegisterPersonalizedTokens(context.Background()))
+	return limiter, clock, store
+}
+
+func TestCheckTokenSchedule(t *testing.T) {
+	limiter, clock, _ := newScheduledLimiter(t)
+
+	assert.NoError(t, limiter.CheckTokenSchedule("old"))
+	assert.NoError(t, limiter.CheckTokenSchedule("new"), "tokens without a schedule are always valid")
+	assert.ErrorIs(t, limiter.CheckTokenSchedule("future"), ErrTokenNotYetValid)
+	assert.ErrorIs(t, limiter.CheckTokenSchedule("expired"), ErrTokenExpired)
+
+	clock.Advance(time.Hour)
+	assert.ErrorIs(t, limiter.CheckTokenSchedule("old"), ErrTokenExpired)
+	assert.NoError(t, limiter.CheckTokenSchedule("future"))
+}
+
+func TestRegisterPersonalizedTokens_Expiry(t *testing.T) {
+	_, _, store := newScheduledLimiter(t)
+
+	_, registered := store.policies["expired"]
+	assert.False(t, registered, "an expired token must not be registered")
+
+	_, registered = store.policies["old"]
+	assert.True(t, registered)
+}
+
+func TestTokenRotation_SharesQuota(t *testing.T) {
+	ctx := context.Background()
+	limiter, clock, _ := newScheduledLimiter(t)
+
+	for _, token := range []string{"old", "new", "old"} {
+		exceeded, err := limiter.IsRateLimitExceeded(ctx, token, true)
+		assert.NoError(t, err)
+		assert.False(t, exceeded)
+	}
+
+	exceeded, err := limiter.IsRateLimitExceeded(ctx, "new", true)
+	assert.NoError(t, err)
+	assert.True(t, exceeded, "during the overlap both tokens must share the quota of the old one")
+
+	clock.Advance(time.Hour)
+
+	exceeded, err = limiter.IsRateLimitExceeded(ctx, "new", true)
+	assert.NoError(t, err)
+	assert.False(t, exceeded, "once the old token expires the new one must use its own quota")
+}
+
+func TestTokenSchedule_EntryPoints(t *testing.T) {
+	ctx := context.Background()
+	limiter, _, _ := newScheduledLimiter(t)
+
+	_, err := limiter.PolicyLimit(ctx, "future")
+	assert.ErrorIs(t, err, ErrTokenNotYetValid)
+
+	_, err = limiter.CheckN(ctx, "expired", 5, 1)
+	assert.ErrorIs(t, err, ErrTokenExpired)
+
+	allowed, err := limiter.Allow(ctx, "future")
+	assert.ErrorIs(t, err, ErrTokenNotYetValid)
+	assert.False(t, allowed)
+
+	_, err = limiter.IsRateLimitExceeded(ctx, "expired", true)
+	assert.ErrorIs(t, err, ErrTokenExpired)
+}
+
+func TestTokenSchedule_FailOpenKeepsScheduleErrors(t *testing.T) {
+	clock := NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	limiter := New(newMemoryStore(clock),
+		WithClock(clock),
+		WithFailMode(FailOpen),
+		WithPolicies(map[string]int64{"expired": 5}),
+		WithTokenSchedules(map[string]TokenSchedule{"expired": {ExpiresAt: clock.Now().Add(-time.Hour)}}),
+	)
+
+	allowed, err := limiter.Allow(context.Background(), "expired")
+	assert.ErrorIs(t, err, ErrTokenExpired, "an expired token is not a store failure")
+	assert.False(t, allowed)
+}
+
+func TestTokenRotation_SharesQuotaInCheckN(t *testing.T) {
+	ctx := context.Background()
+	limiter, _, _ := newScheduledLimiter(t)
+
+	limit, err := limiter.PolicyLimit(ctx, "new")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), limit, "the new token uses the limit of the token it replaces")
+
+	decision, err := limiter.CheckN(ctx, "old", limit, 2)
+	assert.NoError(t, err)
+	assert.True(t, decision.Allowed)
+
+	decision, err = limiter.CheckN(ctx, "new", limit, 2)
+	assert.NoError(t, err)
+	assert.False(t, decision.Allowed, "the rotation must share the quota in the decision service too")
+
+	allowed, err := limiter.Allow(ctx, "new")
+	assert.NoError(t, err)
+	assert.False(t, allowed)
+}
@@ -0,0 +1,132 @@
+package ratelimiter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jpodlasnisky/ratelimiter/infra/database/contract_db"
+)
+
+// AllowWeighted reports whether a hit weighing weight, such as the bytes of a
+// message, fits now in the window of key under limit. The hit is a single
+// member of the window whatever its weight, so the key should only be counted
+// by the weighted methods.
+func (l *RateLimiter) AllowWeighted(ctx context.Context, key string, limit, weight int64) (bool, error) {
+	r, err := l.reserveWeighted(ctx, key, limit, weight, 0)
+	if err != nil {
+		err = l.handleStoreError(key, err)
+		return err == nil, err
+	}
+
+	l.metrics.RecordDecision(key, false, !r.OK())
+	return r.OK(), nil
+}
+
+// WaitWeighted blocks until a hit weighing weight fits in the window of key
+// or the context is done.
+func (l *RateLimiter) WaitWeighted(ctx context.Context, key string, limit, weight int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	maxWait := InfDuration
+	if deadline, ok := ctx.Deadline(); ok {
+		maxWait = deadline.Sub(l.clock.Now())
+	}
+
+	r, err := l.reserveWeighted(ctx, key, limit, weight, maxWait)
+	if err != nil {
+		return l.handleStoreError(key, err)
+	}
+	if !r.OK() {
+		if r.timeToAct.IsZero() {
+			return fmt.Errorf("ratelimiter: Wait(weight=%d) exceeds the limit of key %s", weight, l.logKey(key))
+		}
+		return fmt.Errorf("ratelimiter: Wait(weight=%d) would exceed the context deadline", weight)
+	}
+	return l.waitReservation(ctx, key, r)
+}
+
+// reserveWeighted books one hit weighing weight, like reserveN books n hits:
+// it is recorded before the window is weighed and given back when it doesn't
+// fit within maxWait.
+func (l *RateLimiter) reserveWeighted(ctx context.Context, key string, limit, weight int64, maxWait time.Duration) (*Reservation, error) {
+	if weight < 0 {
+		return nil, ErrNegativeHits
+	}
+
+	reservation := &Reservation{limiter: l}
+	if weight > limit {
+		return reservation, nil
+	}
+
+	now := l.clock.Now()
+	storeKey := l.storeKey(key)
+	expiresAt := l.hitExpiry(now)
+
+	hitID := contract_db.WeightedHitID(l.newHitID(now), weight)
+	if err := l.Store.RecordHit(ctx, storeKey, hitID, expiresAt, now); err != nil {
+		return nil, err
+	}
+	reservation.ok = true
+	reservation.keys = []string{storeKey}
+	reservation.hitIDs = []string{hitID}
+
+	actAt := now
+	blockedUntil, isBlocked, err := l.blockedUntil(ctx, key)
+	if err != nil {
+		reservation.Cancel(ctx)
+		return nil, err
+	}
+	if isBlocked {
+		actAt = blockedUntil
+	}
+
+	total, err := l.Store.WeightInWindow(ctx, storeKey, now)
+	if err != nil {
+		reservation.Cancel(ctx)
+		return nil, err
+	}
+
+	// O hit cabe quando total-limit do peso dos outros hits já saiu da janela;
+	// se o nosso hit expira antes disso, ele também entra na soma
+	if over := total - limit; over > 0 {
+		freeAt, err := l.Store.WeightExpiryAt(ctx, storeKey, over)
+		if err == nil && !freeAt.Before(expiresAt) {
+			freeAt, err = l.Store.WeightExpiryAt(ctx, storeKey, over+weight)
+		}
+		if err != nil && !errors.Is(err, contract_db.ErrNotFound) {
+			reservation.Cancel(ctx)
+			return nil, err
+		}
+		if freeAt.After(actAt) {
+			actAt = freeAt
+		}
+	}
+
+	reservation.timeToAct = actAt
+	if actAt.Sub(now) > maxWait {
+		if err := reservation.Cancel(ctx); err != nil {
+			return nil, err
+		}
+		reservation.ok = false
+		return reservation, nil
+	}
+
+	if actAt.After(now) {
+		booked := contract_db.WeightedHitID(l.newHitID(now), weight)
+		if err := l.Store.RecordHit(ctx, storeKey, booked, l.hitExpiry(actAt), now); err != nil {
+			reservation.Cancel(ctx)
+			return nil, err
+		}
+		if err := l.Store.RemoveHit(ctx, storeKey, hitID); err != nil {
+			reservation.Cancel(ctx)
+			return nil, err
+		}
+		reservation.hitIDs = []string{booked}
+	}
+
+	return reservation, nil
+}
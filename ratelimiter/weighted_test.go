@@ -0,0 +1,54 @@
+package ratelimiter
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAllowWeighted(t *testing.T) {
+	ctx := context.Background()
+	limiter, clock := newFakeLimiter(1, time.Second)
+
+	allowed, err := limiter.AllowWeighted(ctx, "stream", 100, 60)
+	assert.NoError(t, err)
+	assert.True(t, allowed)
+
+	allowed, err = limiter.AllowWeighted(ctx, "stream", 100, 60)
+	assert.NoError(t, err)
+	assert.False(t, allowed)
+
+	allowed, err = limiter.AllowWeighted(ctx, "stream", 100, 40)
+	assert.NoError(t, err)
+	assert.True(t, allowed, "a rejected hit must not be weighed")
+
+	count, err := limiter.Store.CountInWindow(ctx, "stream", clock.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), count, "each hit is a single member")
+
+	clock.Advance(time.Second)
+	allowed, err = limiter.AllowWeighted(ctx, "stream", 100, 100)
+	assert.NoError(t, err)
+	assert.True(t, allowed)
+
+	_, err = limiter.AllowWeighted(ctx, "stream", 100, -1)
+	assert.ErrorIs(t, err, ErrNegativeHits)
+}
+
+func TestReserveWeighted_QueuesAfterWindow(t *testing.T) {
+	ctx := context.Background()
+	limiter, clock := newFakeLimiter(1, time.Second)
+
+	var delays []time.Duration
+	for i := 0; i < 3; i++ {
+		r, err := limiter.reserveWeighted(ctx, "stream", 100, 60, InfDuration)
+		assert.NoError(t, err)
+		assert.True(t, r.OK())
+		delays = append(delays, r.Delay())
+		clock.Advance(100 * time.Millisecond)
+	}
+
+	assert.Equal(t, []time.Duration{0, 900 * time.Millisecond, 1800 * time.Millisecond}, delays)
+}